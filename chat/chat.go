@@ -7,6 +7,10 @@ import (
 
 type Chat interface {
 	SendMessage(chatId int64, message string)
+	BroadcastMessage(chatIds []int64, message string)
 	SendQuestion(db *database.Database, questionId int64, usersChatIds []int64)
+	BroadcastQuestion(db *database.Database, questionId int64, usersChatIds []int64)
+	UpdateQuestion(db *database.Database, questionId int64)
 	SendDialog(dialog *dialog.Dialog, chatId int64)
+	SendDocument(chatId int64, fileName string, content []byte)
 }
@@ -5,19 +5,135 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
 	_ "github.com/mattn/go-sqlite3"
-	"log"
+	"github.com/sirupsen/logrus"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func init() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-}
+// logger reports the errors the database can't recover from, it panics
+// so the code that handles an update can add its context before exiting
+var logger = logrus.WithField("component", "database")
 
 type Database struct {
 	// connection
 	conn *sql.DB
+	// order of pending questions for users
+	questionsOrder int
+}
+
+const (
+	QuestionsOrderOldest = iota
+	QuestionsOrderDeadline
+	QuestionsOrderFewestAnswers
+)
+
+const (
+	ResultsModeAll = iota
+	ResultsModeAnswered
+	ResultsModeNone
+)
+
+const (
+	QuestionStatusEditing = iota
+	QuestionStatusOpen
+	QuestionStatusClosed
+	// hidden from the users after reports until a moderator reviews it
+	QuestionStatusSuspended
+	// edited question of a survey that waits for the rest of the survey to be committed
+	QuestionStatusSurveyDraft
+)
+
+const (
+	SurveyStatusEditing = iota
+	SurveyStatusOpen
+	SurveyStatusClosed
+	// hidden from the users with all its questions after reports until a moderator reviews it
+	SurveyStatusSuspended
+)
+
+const (
+	// nobody sees the results until the question is closed
+	ResultsVisibilityAtClose = iota
+	// the respondents see the current results after they vote, the author sees them all the time
+	ResultsVisibilityAfterVote
+	// only the author sees the current results
+	ResultsVisibilityAuthor
+)
+
+const (
+	// the variants are arbitrary texts
+	QuestionTypeChoice = iota
+	// the variants are the numbers of a scale, the results include statistics
+	QuestionTypeScale
+	// the respondents order the variants, the results are counted with instant-runoff
+	QuestionTypeRanked
+)
+
+const (
+	RoleUser = iota
+	RoleModerator
+	RoleAdmin
+)
+
+type QuietHours struct {
+	Timezone string
+	Start    int // minutes from midnight
+	End      int
+}
+
+type BanInfo struct {
+	UserId      int64
+	ChatId      int64
+	Reason      string
+	BannedUntil int64 // 0 if the ban is permanent
+}
+
+type Voter struct {
+	VariantIndex int
+	DisplayName  string
+	Username     string
+}
+
+type ModerationLogRecord struct {
+	Id          int64
+	Time        int64
+	ModeratorId int64
+	Action      string
+	Target      string
+	Reason      string
+}
+
+type QuestionTemplate struct {
+	Id       int64
+	Name     string
+	Variants []string
+	// the variants are the numbers of a scale if ScaleMax is greater than ScaleMin,
+	// only the built-in templates can be scales
+	ScaleMin   int
+	ScaleMax   int
+	MinAnswers int
+	MaxAnswers int
+	Hours      int64
+}
+
+type OutboxMessage struct {
+	Id            int64
+	ChatId        int64
+	Text          string
+	Priority      int
+	Attempts      int
+	EditMessageId int64
+	// if set, the text is sent as a file with this name
+	DocumentName string
+}
+
+type DeliveredMessage struct {
+	ChatId    int64
+	MessageId int64
 }
 
 func sanitizeString(input string) (result string) {
@@ -27,20 +143,51 @@ func sanitizeString(input string) (result string) {
 }
 
 func (database *Database) execQuery(query string) {
+	defer metrics.ObserveQueryLatency(time.Now())
 	_, err := database.conn.Exec(query)
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.WithField("query", query).Panic(err.Error())
+	}
+}
+
+// queryRows records the latency of the query when the rows are closed,
+// SQLite fetches the rows lazily so the most of the work is done while they are iterated
+type queryRows struct {
+	*sql.Rows
+	startTime time.Time
+	isClosed  bool
+}
+
+func (rows *queryRows) Close() error {
+	if !rows.isClosed {
+		rows.isClosed = true
+		metrics.ObserveQueryLatency(rows.startTime)
+	}
+	return rows.Rows.Close()
+}
+
+func (database *Database) query(query string) (*queryRows, error) {
+	startTime := time.Now()
+	rows, err := database.conn.Query(query)
+	if err != nil {
+		metrics.ObserveQueryLatency(startTime)
+		return nil, err
 	}
+	return &queryRows{Rows: rows, startTime: startTime}, nil
 }
 
 func (database *Database) Connect(fileName string) error {
 	db, err := sql.Open("sqlite3", fileName)
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return err
 	}
 
+	// the database is used from several goroutines, one connection serializes access
+	// and keeps the pragmas applied to every query
+	db.SetMaxOpenConns(1)
+
 	database.conn = db
 
 	database.execQuery("PRAGMA foreign_keys = ON")
@@ -55,6 +202,22 @@ func (database *Database) Connect(fileName string) error {
 		",chat_id INTEGER UNIQUE NOT NULL" +
 		",is_ready INTEGER NOT NULL" +
 		",banned INTEGER" +
+		",ban_reason STRING" +
+		",banned_until INTEGER" + // 0 or NULL if the ban is permanent
+		",role INTEGER NOT NULL DEFAULT 0" + // 0 - user, 1 - moderator, 2 - admin
+		",display_name STRING" + // the name from the Telegram profile
+		",username STRING" +
+		",is_active INTEGER NOT NULL DEFAULT 1" + // 0 if the user blocked the bot
+		",subscription INTEGER NOT NULL DEFAULT 0" + // 0 - subscribed, 1 - stopped, 2 - paused
+		",paused_until INTEGER" +
+		",timezone STRING" +
+		",quiet_start INTEGER" + // minutes from midnight in the user's timezone
+		",quiet_end INTEGER" +
+		",results_mode INTEGER NOT NULL DEFAULT 0" + // 0 - all results, 1 - answered questions only, 2 - none
+		",digest_time INTEGER" + // minutes from midnight, NULL if the user doesn't use digest mode
+		",next_digest_time INTEGER" +
+		",last_digest_time INTEGER" +
+		",current_question INTEGER" + // the last question that was sent to the user
 		")")
 
 	database.execQuery("CREATE UNIQUE INDEX IF NOT EXISTS" +
@@ -64,10 +227,18 @@ func (database *Database) Connect(fileName string) error {
 		" questions(id INTEGER NOT NULL PRIMARY KEY" +
 		",author INTEGER" +
 		",text STRING" +
-		",status INTEGER NOT NULL" + // 0 - editing, 1 - opened, 2 - closed
+		",status INTEGER NOT NULL" + // 0 - editing, 1 - opened, 2 - closed, 3 - suspended, 4 - survey draft
 		",min_votes INTEGER" +
 		",max_votes INTEGER" +
 		",end_time INTEGER" +
+		",finish_time INTEGER" +
+		",commit_time INTEGER" +
+		",is_public INTEGER NOT NULL DEFAULT 0" + // 1 if the results show who voted for each variant
+		",results_visibility INTEGER NOT NULL DEFAULT 0" + // 0 - at close, 1 - after voting, 2 - live for the author
+		",is_edited INTEGER NOT NULL DEFAULT 0" + // the text was changed after the question got answers
+		",question_type INTEGER NOT NULL DEFAULT 0" +
+		",survey_id INTEGER" + // NULL for the questions that are not a part of a survey
+		",survey_position INTEGER" +
 		",FOREIGN KEY(author) REFERENCES users(id) ON DELETE SET NULL" +
 		")")
 
@@ -77,6 +248,7 @@ func (database *Database) Connect(fileName string) error {
 		",text STRING NOT NULL" +
 		",votes_count INTEGER NOT NULL" +
 		",index_number INTEGER NOT NULL" +
+		",value INTEGER" + // the number of the variant of a scale question
 		",FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE" +
 		")")
 
@@ -84,6 +256,7 @@ func (database *Database) Connect(fileName string) error {
 		" answered_questions(id INTEGER NOT NULL PRIMARY KEY" +
 		",user_id INTEGER NOT NULL" +
 		",question_id INTEGER NOT NULL" +
+		",variant_index INTEGER" + // NULL for the answers recorded before it was stored
 		",FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE" +
 		",FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE" +
 		")")
@@ -96,6 +269,73 @@ func (database *Database) Connect(fileName string) error {
 		",FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE" +
 		")")
 
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" question_reports(id INTEGER NOT NULL PRIMARY KEY" +
+		",question_id INTEGER NOT NULL" +
+		",user_id INTEGER NOT NULL" +
+		",time INTEGER NOT NULL" +
+		",UNIQUE(question_id, user_id)" +
+		",FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE" +
+		",FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE" +
+		")")
+
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" moderation_log(id INTEGER NOT NULL PRIMARY KEY" +
+		",time INTEGER NOT NULL" +
+		",moderator_id INTEGER NOT NULL" +
+		",action STRING NOT NULL" +
+		",target STRING" +
+		",reason STRING" +
+		")")
+
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" surveys(id INTEGER NOT NULL PRIMARY KEY" +
+		",author INTEGER NOT NULL" +
+		",status INTEGER NOT NULL" + // 0 - editing, 1 - open, 2 - closed, 3 - suspended
+		")")
+
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" ballot_ranks(id INTEGER NOT NULL PRIMARY KEY" +
+		",question_id INTEGER NOT NULL" +
+		",user_id INTEGER NOT NULL" +
+		",rank INTEGER NOT NULL" + // 0 for the most preferred variant
+		",variant_index INTEGER NOT NULL" +
+		",FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE" +
+		",FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE" +
+		")")
+
+	database.execQuery("CREATE INDEX IF NOT EXISTS" +
+		" ballot_ranks_question_index ON ballot_ranks(question_id, user_id)")
+
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" question_templates(id INTEGER NOT NULL PRIMARY KEY" +
+		",name STRING NOT NULL" +
+		",variants STRING NOT NULL" + // one variant per line
+		",min_votes INTEGER NOT NULL" +
+		",max_votes INTEGER NOT NULL" +
+		",duration INTEGER NOT NULL" + // hours
+		",language STRING NOT NULL" +
+		")")
+
+	database.execQuery("CREATE TABLE IF NOT EXISTS" +
+		" outbox(id INTEGER NOT NULL PRIMARY KEY" +
+		",chat_id INTEGER NOT NULL" +
+		",text STRING NOT NULL" +
+		",priority INTEGER NOT NULL" +
+		",status INTEGER NOT NULL" + // 0 - pending, 1 - delivered, 2 - failed
+		",attempts INTEGER NOT NULL" +
+		",next_attempt_time INTEGER NOT NULL" +
+		",delivery_time INTEGER" +
+		",last_error STRING" +
+		",question_id INTEGER" + // set for the question messages, so they can be updated later
+		",message_id INTEGER" + // id of the delivered message in the chat
+		",edit_message_id INTEGER" + // if set, the message with this id is edited instead of sending a new one
+		",document_name STRING" + // if set, the text is sent as a file with this name
+		")")
+
+	database.execQuery("CREATE INDEX IF NOT EXISTS" +
+		" outbox_status_index ON outbox(status, next_attempt_time)")
+
 	return nil
 }
 
@@ -108,53 +348,13 @@ func (database *Database) IsConnectionOpened() bool {
 	return database.conn != nil
 }
 
-func (database *Database) createUniqueRecord(table string, values string) int64 {
-	var err error
-	if len(values) == 0 {
-		_, err = database.conn.Exec(fmt.Sprintf("INSERT INTO %s DEFAULT VALUES ", table))
-	} else {
-		_, err = database.conn.Exec(fmt.Sprintf("INSERT INTO %s VALUES (%s)", table, values))
-	}
-
-	if err != nil {
-		log.Fatal(err.Error())
-		return -1
-	}
-
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM %s ORDER BY id DESC LIMIT 1", table))
-
-	if err != nil {
-		log.Fatal(err.Error())
-		return -1
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		var id int64
-		err := rows.Scan(&id)
-		if err != nil {
-			log.Fatal(err.Error())
-			return -1
-		}
-
-		return id
-	}
-	err = rows.Err()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Fatal("No record created")
-	return -1
-}
-
 func (database *Database) GetUserId(chatId int64) (userId int64) {
 	database.execQuery(fmt.Sprintf("INSERT OR IGNORE INTO users(chat_id, is_ready) "+
 		"VALUES (%d, 1)", chatId))
 
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM users WHERE chat_id=%d", chatId))
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE chat_id=%d", chatId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -162,23 +362,23 @@ func (database *Database) GetUserId(chatId int64) (userId int64) {
 	if rows.Next() {
 		err := rows.Scan(&userId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No user found")
+		logger.Panic("No user found")
 	}
 
 	return
 }
 
 func (database *Database) GetUserChatId(userId int64) (chatId int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT chat_id FROM users WHERE id=%d", userId))
+	rows, err := database.query(fmt.Sprintf("SELECT chat_id FROM users WHERE id=%d", userId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -186,70 +386,105 @@ func (database *Database) GetUserChatId(userId int64) (chatId int64) {
 	if rows.Next() {
 		err := rows.Scan(&chatId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No user found")
+		logger.Panic("No user found")
 	}
 
 	return
 }
 
 func (database *Database) GetUserEditingQuestion(userId int64) (questionId int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM questions WHERE status=0 AND author=%d", userId))
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM questions WHERE status=0 AND author=%d", userId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
 }
 
+func (database *Database) SetQuestionsOrder(order int) {
+	database.questionsOrder = order
+}
+
+func (database *Database) getQuestionsOrderClause() string {
+	switch database.questionsOrder {
+	case QuestionsOrderDeadline:
+		// questions without a deadline go last
+		return fmt.Sprintf("CASE WHEN q.end_time>%d THEN q.end_time ELSE %d END ASC, pq.question_id ASC", time.Now().Unix(), math.MaxInt64)
+	case QuestionsOrderFewestAnswers:
+		return "(SELECT COUNT(*) FROM answered_questions as aq WHERE aq.question_id=pq.question_id) ASC, pq.question_id ASC"
+	default:
+		return "pq.question_id ASC"
+	}
+}
+
+// getNextQuestionQuery makes the query that selects the question that the user is answering now
+// or the next pending question, userIdExpression can be a column of an outer query
+func (database *Database) getNextQuestionQuery(userIdExpression string) string {
+	// questions of a survey are given in their order, a survey where the user answered a question
+	// is continued before other questions, the surveys that are not started follow the configured order
+	return fmt.Sprintf("SELECT pq.question_id FROM pending_questions as pq"+
+		" INNER JOIN users as u ON u.id=pq.user_id"+
+		" INNER JOIN questions as q ON q.id=pq.question_id"+
+		" WHERE pq.user_id=%s AND (pq.question_id=u.current_question OR NOT EXISTS"+
+		" (SELECT spq.question_id FROM pending_questions as spq INNER JOIN questions as sq ON sq.id=spq.question_id"+
+		" WHERE spq.user_id=pq.user_id AND sq.survey_id=q.survey_id AND sq.survey_position<q.survey_position))"+
+		" ORDER BY COALESCE(pq.question_id=u.current_question, 0) DESC"+
+		", EXISTS (SELECT aq.question_id FROM answered_questions as aq INNER JOIN questions as aqq ON aqq.id=aq.question_id"+
+		" WHERE aq.user_id=pq.user_id AND aqq.survey_id=q.survey_id) DESC"+
+		", %s LIMIT 1", userIdExpression, database.getQuestionsOrderClause())
+}
+
+// GetUserNextQuestion returns the question that the user is answering now
+// or the next pending question if the user doesn't have one
 func (database *Database) GetUserNextQuestion(userId int64) (questionId int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT MIN(question_id) FROM pending_questions WHERE user_id=%d", userId))
+	rows, err := database.query(database.getNextQuestionQuery(strconv.FormatInt(userId, 10)))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
 }
 
 func (database *Database) IsUserEditingQuestion(userId int64) bool {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE status=0 AND author=%d", userId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE status=0 AND author=%d", userId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -257,13 +492,13 @@ func (database *Database) IsUserEditingQuestion(userId int64) bool {
 		count := 0
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 			return false
 		}
 
 		if count != 0 {
 			if count != 1 {
-				log.Fatalf("Count should be 0 or 1: %d", count)
+				logger.Panicf("Count should be 0 or 1: %d", count)
 			}
 			return true
 		}
@@ -271,18 +506,18 @@ func (database *Database) IsUserEditingQuestion(userId int64) bool {
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 			return false
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 		return false
 	}
 }
 
 func (database *Database) IsUserHasPendingQuestions(userId int64) bool {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM pending_questions WHERE user_id=%d", userId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM pending_questions WHERE user_id=%d", userId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -290,7 +525,7 @@ func (database *Database) IsUserHasPendingQuestions(userId int64) bool {
 		count := 0
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 			return false
 		}
 
@@ -298,42 +533,42 @@ func (database *Database) IsUserHasPendingQuestions(userId int64) bool {
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 			return false
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 		return false
 	}
 }
 
 func (database *Database) GetQuestionText(questionId int64) (text string) {
 	text = ""
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT text FROM questions WHERE id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT text FROM questions WHERE id=%d", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&text)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
 }
 
 func (database *Database) GetQuestionVariants(questionId int64) (variants []string) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT text FROM variants WHERE question_id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT text FROM variants WHERE question_id=%d", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -341,7 +576,7 @@ func (database *Database) GetQuestionVariants(questionId int64) (variants []stri
 		var variant string
 		err := rows.Scan(&variant)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		variants = append(variants, variant)
 	}
@@ -350,46 +585,46 @@ func (database *Database) GetQuestionVariants(questionId int64) (variants []stri
 }
 
 func (database *Database) GetQuestionVariantsCount(questionId int64) (count int) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM variants WHERE question_id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM variants WHERE question_id=%d", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
 }
 
 func (database *Database) GetQuestionRules(questionId int64) (minAnswers int, maxAnswers int, endTime int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT min_votes,max_votes,end_time FROM questions WHERE id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT min_votes,max_votes,end_time FROM questions WHERE id=%d", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&minAnswers, &maxAnswers, &endTime)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
@@ -397,9 +632,9 @@ func (database *Database) GetQuestionRules(questionId int64) (minAnswers int, ma
 }
 
 func (database *Database) GetQuestionAnswers(questionId int64) (answers []int) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT votes_count FROM variants WHERE question_id=%d ORDER BY index_number ASC", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT votes_count FROM variants WHERE question_id=%d ORDER BY index_number ASC", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -407,7 +642,7 @@ func (database *Database) GetQuestionAnswers(questionId int64) (answers []int) {
 		var answer int
 		err := rows.Scan(&answer)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		answers = append(answers, answer)
 	}
@@ -416,23 +651,23 @@ func (database *Database) GetQuestionAnswers(questionId int64) (answers []int) {
 }
 
 func (database *Database) GetQuestionAnswersCount(questionId int64) (count int) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM answered_questions WHERE question_id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM answered_questions WHERE question_id=%d", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
@@ -455,6 +690,10 @@ func (database *Database) SetQuestionText(questionId int64, text string) {
 func (database *Database) SetQuestionVariants(questionId int64, variants []string) {
 	// delete the old variants
 	database.execQuery(fmt.Sprintf("DELETE FROM variants WHERE question_id=%d", questionId))
+	// variants without values can't make a scale
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET question_type=%d WHERE id=%d AND question_type=%d", QuestionTypeChoice, questionId, QuestionTypeScale))
+	// the ballots that are being filled refer to the old variants
+	database.execQuery(fmt.Sprintf("DELETE FROM ballot_ranks WHERE question_id=%d", questionId))
 
 	// add the new ones
 	var buffer bytes.Buffer
@@ -472,8 +711,66 @@ func (database *Database) SetQuestionVariants(questionId int64, variants []strin
 	}
 }
 
+// SetQuestionScale replaces the variants with the numbers from minValue to maxValue
+func (database *Database) SetQuestionScale(questionId int64, minValue int, maxValue int) {
+	database.execQuery(fmt.Sprintf("DELETE FROM variants WHERE question_id=%d", questionId))
+	database.execQuery(fmt.Sprintf("DELETE FROM ballot_ranks WHERE question_id=%d", questionId))
+
+	var buffer bytes.Buffer
+	for value := minValue; value <= maxValue; value++ {
+		buffer.WriteString(fmt.Sprintf("(%d,'%d',0,%d,%d)", questionId, value, value-minValue, value))
+		if value < maxValue {
+			buffer.WriteString(",")
+		}
+	}
+
+	database.execQuery(fmt.Sprintf("INSERT INTO variants (question_id, text, votes_count, index_number, value) VALUES %s", buffer.String()))
+	database.SetQuestionType(questionId, QuestionTypeScale)
+}
+
+// GetQuestionVariantValues returns the numbers of the variants of a scale question
+func (database *Database) GetQuestionVariantValues(questionId int64) (values []int) {
+	rows, err := database.query(fmt.Sprintf("SELECT value FROM variants WHERE question_id=%d AND value NOT NULL ORDER BY index_number ASC", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value int
+		err := rows.Scan(&value)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		values = append(values, value)
+	}
+
+	return
+}
+
+func (database *Database) SetQuestionType(questionId int64, questionType int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET question_type=%d WHERE id=%d", questionType, questionId))
+}
+
+func (database *Database) GetQuestionType(questionId int64) (questionType int) {
+	rows, err := database.query(fmt.Sprintf("SELECT question_type FROM questions WHERE id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&questionType)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
 func (database *Database) AddQuestionAnswer(questionId int64, userId int64, index int64) {
-	database.execQuery(fmt.Sprintf("INSERT INTO answered_questions (user_id, question_id) VALUES (%d,%d)", userId, questionId))
+	database.execQuery(fmt.Sprintf("INSERT INTO answered_questions (user_id, question_id, variant_index) VALUES (%d,%d,%d)", userId, questionId, index))
 
 	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK variants SET votes_count=votes_count+1 WHERE question_id=%d AND index_number=%d", questionId, index))
 }
@@ -483,9 +780,9 @@ func (database *Database) RemoveUserPendingQuestion(userId int64, questionId int
 }
 
 func (database *Database) GetQuestionRespondents(questionId int64) (respondents []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT u.chat_id FROM answered_questions as q INNER JOIN users as u WHERE q.question_id=%d AND q.user_id=u.id", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT u.chat_id FROM answered_questions as q INNER JOIN users as u WHERE q.question_id=%d AND q.user_id=u.id", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -493,7 +790,7 @@ func (database *Database) GetQuestionRespondents(questionId int64) (respondents
 		var respondent int64
 		err := rows.Scan(&respondent)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		respondents = append(respondents, respondent)
 	}
@@ -502,9 +799,9 @@ func (database *Database) GetQuestionRespondents(questionId int64) (respondents
 }
 
 func (database *Database) GetReadyUsersChatIds() (users []int64) {
-	rows, err := database.conn.Query("SELECT chat_id FROM users WHERE is_ready=1")
+	rows, err := database.query("SELECT chat_id FROM users WHERE is_ready=1 AND is_active=1 AND subscription=0 AND digest_time IS NULL")
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -513,7 +810,7 @@ func (database *Database) GetReadyUsersChatIds() (users []int64) {
 		var chatId int64
 		err := rows.Scan(&chatId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		users = append(users, chatId)
 	}
@@ -522,9 +819,9 @@ func (database *Database) GetReadyUsersChatIds() (users []int64) {
 }
 
 func (database *Database) GetAllUsersChatIds() (chatIds []int64) {
-	rows, err := database.conn.Query("SELECT chat_id FROM users")
+	rows, err := database.query("SELECT chat_id FROM users WHERE is_active=1")
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -532,7 +829,7 @@ func (database *Database) GetAllUsersChatIds() (chatIds []int64) {
 		var chatId int64
 		err := rows.Scan(&chatId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		chatIds = append(chatIds, chatId)
 	}
@@ -540,15 +837,42 @@ func (database *Database) GetAllUsersChatIds() (chatIds []int64) {
 	return
 }
 
+// GetQuestionDuration returns for how many hours the question was planned to be open when it was committed,
+// extensions of the deadline are included, ok is false for the questions committed before the time was stored
+func (database *Database) GetQuestionDuration(questionId int64) (hours int64, ok bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT end_time, commit_time FROM questions"+
+		" WHERE id=%d AND end_time NOT NULL AND commit_time NOT NULL", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var endTime int64
+		var commitTime int64
+		err := rows.Scan(&endTime, &commitTime)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		if endTime >= commitTime {
+			// round to the closest hour
+			hours = (endTime - commitTime + 1800) / 3600
+			ok = true
+		}
+	}
+
+	return
+}
+
 func (database *Database) StartCreatingQuestion(author int64) {
 	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET is_ready=0 WHERE id=%d", author))
-	database.createUniqueRecord("questions", fmt.Sprintf("NULL,%d,NULL,0,NULL,NULL,NULL", author))
+	database.execQuery(fmt.Sprintf("INSERT INTO questions (author, status) VALUES (%d, 0)", author))
 }
 
 func (database *Database) IsQuestionReady(questionId int64) (isReady bool) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND text NOT NULL AND end_time NOT NULL AND min_votes NOT NULL AND max_votes NOT NULL", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND text NOT NULL AND end_time NOT NULL AND min_votes NOT NULL AND max_votes NOT NULL", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -557,33 +881,33 @@ func (database *Database) IsQuestionReady(questionId int64) (isReady bool) {
 		var count int64
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 
 		if count != 0 {
 			isReady = true
 
 			if count != 1 {
-				log.Fatalf("Count should be 0 or 1: %d", count)
+				logger.Panicf("Count should be 0 or 1: %d", count)
 			}
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No row found")
+		logger.Panic("No row found")
 	}
 
 	return
 }
 
 func (database *Database) CommitQuestion(questionId int64) {
-	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=1 WHERE id=%d", questionId))
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=1, commit_time=%d WHERE id=%d", time.Now().Unix(), questionId))
 
 	// add to pending questions for all users
 	database.conn.Exec(fmt.Sprintf("INSERT INTO pending_questions (user_id, question_id) "+
-		"SELECT DISTINCT id, %d FROM users;", questionId))
+		"SELECT DISTINCT id, %d FROM users WHERE is_active=1 AND subscription=0;", questionId))
 }
 
 func (database *Database) DiscardQuestion(questionId int64) {
@@ -591,7 +915,7 @@ func (database *Database) DiscardQuestion(questionId int64) {
 }
 
 func (database *Database) FinishQuestion(questionId int64) {
-	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=2 WHERE id=%d", questionId))
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=2, finish_time=%d WHERE id=%d", time.Now().Unix(), questionId))
 }
 
 func (database *Database) MarkUserReady(userId int64) {
@@ -617,17 +941,33 @@ func (database *Database) UnmarkUsersReady(chatIds []int64) {
 	}
 }
 
+func (database *Database) SetUsersCurrentQuestion(chatIds []int64, questionId int64) {
+	count := len(chatIds)
+	if count > 0 {
+		var buffer bytes.Buffer
+		for i, chatId := range chatIds {
+			buffer.WriteString(strconv.FormatInt(chatId, 10))
+			if i < count-1 {
+				buffer.WriteString(",")
+			}
+		}
+
+		database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET current_question=%d WHERE chat_id IN (%s)", questionId, buffer.String()))
+	}
+}
+
 func (database *Database) RemoveQuestionFromAllUsers(questionId int64) {
 	database.execQuery(fmt.Sprintf("DELETE FROM pending_questions WHERE question_id=%d", questionId))
 }
 
 func (database *Database) GetUsersAnsweringQuestionNow(questionId int64) (users []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT t.user_id FROM"+
-		" (SELECT user_id, MIN(question_id) as next_question_id FROM pending_questions GROUP BY user_id) as t"+
-		" WHERE t.next_question_id=%d", questionId))
-
+	// users in digest mode don't see questions one by one
+	rows, err := database.query(fmt.Sprintf("SELECT apq.user_id FROM pending_questions as apq"+
+		" INNER JOIN users as au ON au.id=apq.user_id"+
+		" WHERE apq.question_id=%d AND au.digest_time IS NULL AND (%s)=%d",
+		questionId, database.getNextQuestionQuery("apq.user_id"), questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -635,7 +975,7 @@ func (database *Database) GetUsersAnsweringQuestionNow(questionId int64) (users
 		var user int64
 		err := rows.Scan(&user)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		users = append(users, user)
 	}
@@ -644,33 +984,33 @@ func (database *Database) GetUsersAnsweringQuestionNow(questionId int64) (users
 }
 
 func (database *Database) GetQuestionPendingCount(questionId int64) (count int) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT count(*) FROM pending_questions WHERE question_id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT count(*) FROM pending_questions WHERE question_id=%d", questionId))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No question found")
+		logger.Panic("No question found")
 	}
 
 	return
 }
 
 func (database *Database) IsQuestionHasText(questionId int64) (hasText bool) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND text NOT NULL", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND text NOT NULL", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -679,31 +1019,31 @@ func (database *Database) IsQuestionHasText(questionId int64) (hasText bool) {
 		var count int64
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 
 		if count != 0 {
 			hasText = true
 
 			if count != 1 {
-				log.Fatalf("Count should be 0 or 1: %d", count)
+				logger.Panicf("Count should be 0 or 1: %d", count)
 			}
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No row found")
+		logger.Panic("No row found")
 	}
 
 	return
 }
 
 func (database *Database) IsQuestionHasRules(questionId int64) (hasRules bool) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND end_time NOT NULL AND min_votes NOT NULL AND max_votes NOT NULL", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE id=%d AND end_time NOT NULL AND min_votes NOT NULL AND max_votes NOT NULL", questionId))
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -712,32 +1052,32 @@ func (database *Database) IsQuestionHasRules(questionId int64) (hasRules bool) {
 		var count int64
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 
 		if count != 0 {
 			hasRules = true
 
 			if count != 1 {
-				log.Fatalf("Count should be 0 or 1: %d", count)
+				logger.Panicf("Count should be 0 or 1: %d", count)
 			}
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
-		log.Fatal("No row found")
+		logger.Panic("No row found")
 	}
 
 	return
 }
 
 func (database *Database) GetActiveQuestions() (activeQuestions []int64) {
-	rows, err := database.conn.Query("SELECT id FROM questions WHERE status=1")
+	rows, err := database.query("SELECT id FROM questions WHERE status=1")
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -745,7 +1085,7 @@ func (database *Database) GetActiveQuestions() (activeQuestions []int64) {
 		var questionId int64
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		activeQuestions = append(activeQuestions, questionId)
 	}
@@ -764,13 +1104,13 @@ func (database *Database) InitNewUserQuestions(userId int64) {
 }
 
 func (database *Database) GetLastFinishedQuestions(count int) (questions []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM"+
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM"+
 		"(SELECT q.id as id FROM questions as q"+
 		" WHERE q.status=2"+
 		" ORDER BY q.id DESC LIMIT %d) ORDER BY id ASC", count))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -778,7 +1118,7 @@ func (database *Database) GetLastFinishedQuestions(count int) (questions []int64
 		var questionId int64
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		questions = append(questions, questionId)
 	}
@@ -787,17 +1127,17 @@ func (database *Database) GetLastFinishedQuestions(count int) (questions []int64
 }
 
 func (database *Database) GetDatabaseVersion() (version string) {
-	rows, err := database.conn.Query("SELECT string_value FROM global_vars WHERE name=\"version\"")
+	rows, err := database.query("SELECT string_value FROM global_vars WHERE name=\"version\"")
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&version)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		// that means it's a new clean database
@@ -813,10 +1153,11 @@ func (database *Database) SetDatabaseVersion(version string) {
 }
 
 func (database *Database) IsUserBanned(userId int64) (isBanned bool) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT COUNT(*) FROM users WHERE id=%d AND banned=1", userId))
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM users WHERE id=%d AND banned=1"+
+		" AND (COALESCE(banned_until, 0)=0 OR banned_until>%d)", userId, time.Now().Unix()))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 		return
 	}
 	defer rows.Close()
@@ -825,38 +1166,111 @@ func (database *Database) IsUserBanned(userId int64) (isBanned bool) {
 		var count int64
 		err := rows.Scan(&count)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		if count != 0 {
 			isBanned = true
 
 			if count != 1 {
-				log.Fatalf("Count should be 0 or 1: %d", count)
+				logger.Panicf("Count should be 0 or 1: %d", count)
 			}
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
+		}
+		logger.Panic("No row found")
+	}
+
+	return
+}
+
+// BanUser bans the user until the given time or permanently if bannedUntil is 0
+func (database *Database) BanUser(userId int64, reason string, bannedUntil int64) {
+	database.execQuery(fmt.Sprintf("UPDATE users SET banned=1, ban_reason='%s', banned_until=%d where id=%d", sanitizeString(reason), bannedUntil, userId))
+}
+
+func (database *Database) UnbanUser(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE users SET banned=0, ban_reason=NULL, banned_until=NULL where id=%d", userId))
+}
+
+func (database *Database) GetBannedUsers() (bans []BanInfo) {
+	rows, err := database.query("SELECT id, chat_id, COALESCE(ban_reason, ''), COALESCE(banned_until, 0) FROM users WHERE banned=1 ORDER BY id ASC")
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ban BanInfo
+		err := rows.Scan(&ban.UserId, &ban.ChatId, &ban.Reason, &ban.BannedUntil)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		bans = append(bans, ban)
+	}
+
+	return
+}
+
+func (database *Database) GetUsersWithExpiredBan(currentTime int64) (users []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE banned=1 AND COALESCE(banned_until, 0)<>0 AND banned_until<=%d", currentTime))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int64
+		err := rows.Scan(&userId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		users = append(users, userId)
+	}
+
+	return
+}
+
+// FindUserByChatId returns the id of the user without creating a new one
+func (database *Database) FindUserByChatId(chatId int64) (userId int64, isFound bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE chat_id=%d", chatId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&userId)
+		if err != nil {
+			logger.Panic(err.Error())
 		}
-		log.Fatal("No row found")
+		isFound = true
 	}
 
 	return
 }
 
-func (database *Database) BanUser(userId int64) {
-	database.execQuery(fmt.Sprintf("UPDATE users SET banned=1 where id=%d", userId))
+func (database *Database) IsUserExists(userId int64) (isExists bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	isExists = rows.Next()
+	return
 }
 
 func (database *Database) GetLastPublishedQuestions(count int64) (questions []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM"+
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM"+
 		"(SELECT q.id as id FROM questions as q"+
 		" WHERE q.status=1 OR q.status=2"+
 		" ORDER BY q.id DESC LIMIT %d) ORDER BY id ASC", count))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -864,7 +1278,7 @@ func (database *Database) GetLastPublishedQuestions(count int64) (questions []in
 		var questionId int64
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		questions = append(questions, questionId)
 	}
@@ -877,22 +1291,22 @@ func (database *Database) RemoveQuestion(questionId int64) {
 }
 
 func (database *Database) GetAuthor(questionId int64) (author int64, findErr error) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT author FROM questions WHERE id=%d", questionId))
+	rows, err := database.query(fmt.Sprintf("SELECT author FROM questions WHERE id=%d", questionId))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
 	if rows.Next() {
 		err := rows.Scan(&author)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 	} else {
 		err = rows.Err()
 		if err != nil {
-			log.Fatal(err)
+			logger.Panic(err)
 		}
 		findErr = errors.New("No question found")
 	}
@@ -901,13 +1315,13 @@ func (database *Database) GetAuthor(questionId int64) (author int64, findErr err
 }
 
 func (database *Database) GetUserLastQuestions(userId int64, count int) (questions []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM"+
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM"+
 		"(SELECT q.id as id FROM questions as q"+
 		" WHERE q.author=%d AND (q.status=1 OR q.status=2)"+
 		" ORDER BY q.id DESC LIMIT %d) ORDER BY id ASC", userId, count))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -915,7 +1329,7 @@ func (database *Database) GetUserLastQuestions(userId int64, count int) (questio
 		var questionId int64
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		questions = append(questions, questionId)
 	}
@@ -924,13 +1338,13 @@ func (database *Database) GetUserLastQuestions(userId int64, count int) (questio
 }
 
 func (database *Database) GetUserLastFinishedQuestions(userId int64, count int) (questions []int64) {
-	rows, err := database.conn.Query(fmt.Sprintf("SELECT id FROM"+
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM"+
 		"(SELECT q.id as id FROM questions as q"+
 		" WHERE q.author=%d AND q.status=2"+
 		" ORDER BY q.id DESC LIMIT %d) ORDER BY id ASC", userId, count))
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logger.Panic(err.Error())
 	}
 	defer rows.Close()
 
@@ -938,10 +1352,1208 @@ func (database *Database) GetUserLastFinishedQuestions(userId int64, count int)
 		var questionId int64
 		err := rows.Scan(&questionId)
 		if err != nil {
-			log.Fatal(err.Error())
+			logger.Panic(err.Error())
 		}
 		questions = append(questions, questionId)
 	}
 
 	return
 }
+
+func (database *Database) AddOutboxMessages(chatIds []int64, text string, priority int, sendTime int64) {
+	database.AddOutboxQuestionMessages(chatIds, 0, text, priority, sendTime)
+}
+
+// AddOutboxQuestionMessages adds the messages that show the question to the users,
+// they are remembered to be able to update them when the question is edited
+func (database *Database) AddOutboxQuestionMessages(chatIds []int64, questionId int64, text string, priority int, sendTime int64) {
+	questionIdValue := "NULL"
+	if questionId != 0 {
+		questionIdValue = strconv.FormatInt(questionId, 10)
+	}
+
+	count := len(chatIds)
+	if count > 0 {
+		var buffer bytes.Buffer
+		for i, chatId := range chatIds {
+			buffer.WriteString(fmt.Sprintf("(%d,'%s',%d,0,0,%d,%s)", chatId, sanitizeString(text), priority, sendTime, questionIdValue))
+			if i < count-1 {
+				buffer.WriteString(",")
+			}
+		}
+
+		database.execQuery(fmt.Sprintf("INSERT INTO outbox (chat_id, text, priority, status, attempts, next_attempt_time, question_id) VALUES %s", buffer.String()))
+	}
+}
+
+// AddOutboxDocument adds a file to send, the content is stored as the text of the message
+func (database *Database) AddOutboxDocument(chatId int64, fileName string, content string, priority int, sendTime int64) {
+	database.execQuery(fmt.Sprintf("INSERT INTO outbox (chat_id, text, priority, status, attempts, next_attempt_time, document_name) VALUES (%d,'%s',%d,0,0,%d,'%s')",
+		chatId, sanitizeString(content), priority, sendTime, sanitizeString(fileName)))
+}
+
+// AddOutboxEdits adds the requests to replace the text of the already delivered messages
+func (database *Database) AddOutboxEdits(messages []DeliveredMessage, text string, priority int, sendTime int64) {
+	count := len(messages)
+	if count > 0 {
+		var buffer bytes.Buffer
+		for i, message := range messages {
+			buffer.WriteString(fmt.Sprintf("(%d,'%s',%d,0,0,%d,%d)", message.ChatId, sanitizeString(text), priority, sendTime, message.MessageId))
+			if i < count-1 {
+				buffer.WriteString(",")
+			}
+		}
+
+		database.execQuery(fmt.Sprintf("INSERT INTO outbox (chat_id, text, priority, status, attempts, next_attempt_time, edit_message_id) VALUES %s", buffer.String()))
+	}
+}
+
+func (database *Database) GetDueOutboxMessages(currentTime int64, limit int) (messages []OutboxMessage) {
+	return database.GetNewDueOutboxMessages(0, currentTime, limit)
+}
+
+// GetNewDueOutboxMessages returns the due messages that were added to the outbox after the message with the given id
+func (database *Database) GetNewDueOutboxMessages(afterId int64, currentTime int64, limit int) (messages []OutboxMessage) {
+	rows, err := database.query(fmt.Sprintf("SELECT id, chat_id, text, priority, attempts, COALESCE(edit_message_id, 0), COALESCE(document_name, '') FROM outbox"+
+		" WHERE status=0 AND next_attempt_time<=%d AND id>%d"+
+		" ORDER BY priority ASC, id ASC LIMIT %d", currentTime, afterId, limit))
+
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message OutboxMessage
+		err := rows.Scan(&message.Id, &message.ChatId, &message.Text, &message.Priority, &message.Attempts, &message.EditMessageId, &message.DocumentName)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		messages = append(messages, message)
+	}
+
+	return
+}
+
+// MarkOutboxMessageDelivered marks the message as delivered, sentMessageId is the id of the message in the chat
+func (database *Database) MarkOutboxMessageDelivered(messageId int64, deliveryTime int64, sentMessageId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK outbox SET status=1, attempts=attempts+1, delivery_time=%d, message_id=%d WHERE id=%d", deliveryTime, sentMessageId, messageId))
+}
+
+func (database *Database) MarkOutboxMessageFailed(messageId int64, errorText string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK outbox SET status=2, attempts=attempts+1, last_error='%s' WHERE id=%d", sanitizeString(errorText), messageId))
+}
+
+func (database *Database) RescheduleOutboxMessage(messageId int64, nextAttemptTime int64, errorText string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK outbox SET attempts=attempts+1, next_attempt_time=%d, last_error='%s' WHERE id=%d", nextAttemptTime, sanitizeString(errorText), messageId))
+}
+
+func (database *Database) GetOutboxMessageStatus(messageId int64) (status int) {
+	rows, err := database.query(fmt.Sprintf("SELECT status FROM outbox WHERE id=%d", messageId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&status)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No message found")
+	}
+
+	return
+}
+
+// RemoveDeliveredOutboxMessages removes old delivered messages,
+// messages of the open questions are kept to be able to edit them
+func (database *Database) RemoveDeliveredOutboxMessages(deliveredBefore int64) {
+	database.execQuery(fmt.Sprintf("DELETE FROM outbox WHERE status=1 AND delivery_time<%d"+
+		" AND (question_id IS NULL OR question_id NOT IN (SELECT id FROM questions WHERE status=%d))", deliveredBefore, QuestionStatusOpen))
+}
+
+// GetDeliveredQuestionMessages returns the messages with the question that users already received
+func (database *Database) GetDeliveredQuestionMessages(questionId int64) (messages []DeliveredMessage) {
+	rows, err := database.query(fmt.Sprintf("SELECT chat_id, message_id FROM outbox"+
+		" WHERE question_id=%d AND status=1 AND message_id IS NOT NULL ORDER BY id ASC", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message DeliveredMessage
+		err := rows.Scan(&message.ChatId, &message.MessageId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		messages = append(messages, message)
+	}
+
+	return
+}
+
+// UpdatePendingQuestionMessages replaces the text of the question messages that are not delivered yet
+func (database *Database) UpdatePendingQuestionMessages(questionId int64, text string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK outbox SET text='%s' WHERE question_id=%d AND status=0", sanitizeString(text), questionId))
+}
+
+// MarkUserInactive is called when the user blocked the bot, such user doesn't receive new questions
+func (database *Database) MarkUserInactive(chatId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET is_active=0, is_ready=0 WHERE chat_id=%d", chatId))
+	database.execQuery(fmt.Sprintf("DELETE FROM pending_questions WHERE user_id IN (SELECT id FROM users WHERE chat_id=%d)", chatId))
+}
+
+func (database *Database) ActivateUser(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET is_active=1 WHERE id=%d", userId))
+}
+
+func (database *Database) IsUserActive(userId int64) (isActive bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT is_active FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&isActive)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No user found")
+	}
+
+	return
+}
+
+func (database *Database) FailChatOutboxMessages(chatId int64, errorText string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK outbox SET status=2, last_error='%s' WHERE status=0 AND chat_id=%d", sanitizeString(errorText), chatId))
+}
+
+func (database *Database) GetSubscribedUsersChatIds() (chatIds []int64) {
+	rows, err := database.query("SELECT chat_id FROM users WHERE is_active=1 AND subscription=0")
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatId int64
+		err := rows.Scan(&chatId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		chatIds = append(chatIds, chatId)
+	}
+
+	return
+}
+
+func (database *Database) StopUserSubscription(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET subscription=1, paused_until=NULL, is_ready=0 WHERE id=%d", userId))
+	database.execQuery(fmt.Sprintf("DELETE FROM pending_questions WHERE user_id=%d", userId))
+}
+
+func (database *Database) PauseUserSubscription(userId int64, pausedUntil int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET subscription=2, paused_until=%d, is_ready=0 WHERE id=%d", pausedUntil, userId))
+	database.execQuery(fmt.Sprintf("DELETE FROM pending_questions WHERE user_id=%d", userId))
+}
+
+func (database *Database) ResumeUserSubscription(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET subscription=0, paused_until=NULL WHERE id=%d", userId))
+}
+
+func (database *Database) IsUserSubscribed(userId int64) (isSubscribed bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM users WHERE id=%d AND subscription=0", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var count int64
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isSubscribed = (count > 0)
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No row found")
+	}
+
+	return
+}
+
+func (database *Database) GetUsersWithExpiredPause(currentTime int64) (users []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE subscription=2 AND paused_until<=%d", currentTime))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int64
+		err := rows.Scan(&userId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		users = append(users, userId)
+	}
+
+	return
+}
+
+func (database *Database) SetUserTimezone(userId int64, timezone string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET timezone='%s' WHERE id=%d", sanitizeString(timezone), userId))
+}
+
+func (database *Database) GetUserTimezone(userId int64) (timezone string) {
+	rows, err := database.query(fmt.Sprintf("SELECT COALESCE(timezone, '') FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&timezone)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No user found")
+	}
+
+	return
+}
+
+func (database *Database) SetUserQuietHours(userId int64, start int, end int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET quiet_start=%d, quiet_end=%d WHERE id=%d", start, end, userId))
+}
+
+func (database *Database) RemoveUserQuietHours(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET quiet_start=NULL, quiet_end=NULL WHERE id=%d", userId))
+}
+
+func (database *Database) GetUserQuietHours(userId int64) (start int, end int, isSet bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT quiet_start, quiet_end FROM users WHERE id=%d AND quiet_start NOT NULL AND quiet_end NOT NULL", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&start, &end)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isSet = true
+	}
+
+	return
+}
+
+// GetUsersQuietHours returns quiet hours of all users that have them set, by chat ids
+func (database *Database) GetUsersQuietHours() (quietHours map[int64]QuietHours) {
+	rows, err := database.query("SELECT chat_id, COALESCE(timezone, ''), quiet_start, quiet_end FROM users WHERE quiet_start NOT NULL AND quiet_end NOT NULL")
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	quietHours = make(map[int64]QuietHours)
+	for rows.Next() {
+		var chatId int64
+		var hours QuietHours
+		err := rows.Scan(&chatId, &hours.Timezone, &hours.Start, &hours.End)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		quietHours[chatId] = hours
+	}
+
+	return
+}
+
+func (database *Database) SetUserResultsMode(userId int64, mode int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET results_mode=%d WHERE id=%d", mode, userId))
+}
+
+func (database *Database) GetUserResultsMode(userId int64) (mode int) {
+	rows, err := database.query(fmt.Sprintf("SELECT results_mode FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&mode)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No user found")
+	}
+
+	return
+}
+
+// GetResultsRecipientsChatIds returns subscribed users that want to receive results of the question
+func (database *Database) GetResultsRecipientsChatIds(questionId int64) (chatIds []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT u.chat_id FROM users as u"+
+		" WHERE u.is_active=1 AND u.subscription=0 AND u.digest_time IS NULL AND (u.results_mode=%d OR (u.results_mode=%d AND"+
+		" (u.id IN (SELECT user_id FROM answered_questions WHERE question_id=%d)"+
+		" OR u.id IN (SELECT author FROM questions WHERE id=%d))))",
+		ResultsModeAll, ResultsModeAnswered, questionId, questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatId int64
+		err := rows.Scan(&chatId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		chatIds = append(chatIds, chatId)
+	}
+
+	return
+}
+
+func (database *Database) EnableUserDigest(userId int64, digestTime int, nextDigestTime int64, lastDigestTime int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET digest_time=%d, next_digest_time=%d, last_digest_time=%d, is_ready=0 WHERE id=%d",
+		digestTime, nextDigestTime, lastDigestTime, userId))
+}
+
+func (database *Database) DisableUserDigest(userId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET digest_time=NULL, next_digest_time=NULL, last_digest_time=NULL WHERE id=%d", userId))
+}
+
+func (database *Database) GetUserDigestTime(userId int64) (digestTime int, isEnabled bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT digest_time FROM users WHERE id=%d AND digest_time NOT NULL", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&digestTime)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isEnabled = true
+	}
+
+	return
+}
+
+func (database *Database) IsUserInDigestMode(userId int64) bool {
+	_, isEnabled := database.GetUserDigestTime(userId)
+	return isEnabled
+}
+
+func (database *Database) GetUsersWithDueDigest(currentTime int64) (users []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM users WHERE digest_time NOT NULL AND next_digest_time<=%d AND is_active=1 AND subscription=0", currentTime))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId int64
+		err := rows.Scan(&userId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		users = append(users, userId)
+	}
+
+	return
+}
+
+func (database *Database) GetUserLastDigestTime(userId int64) (lastDigestTime int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT COALESCE(last_digest_time, 0) FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&lastDigestTime)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No user found")
+	}
+
+	return
+}
+
+func (database *Database) SetUserDigestSent(userId int64, sentTime int64, nextDigestTime int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET last_digest_time=%d, next_digest_time=%d WHERE id=%d", sentTime, nextDigestTime, userId))
+}
+
+func (database *Database) GetUserPendingQuestions(userId int64) (questions []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT question_id FROM pending_questions WHERE user_id=%d ORDER BY question_id ASC", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var questionId int64
+		err := rows.Scan(&questionId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		questions = append(questions, questionId)
+	}
+
+	return
+}
+
+func (database *Database) IsQuestionPendingForUser(userId int64, questionId int64) (isPending bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM pending_questions WHERE user_id=%d AND question_id=%d", userId, questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var count int64
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isPending = (count > 0)
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No row found")
+	}
+
+	return
+}
+
+// GetUserFinishedQuestionsSince returns questions finished after the given time
+// which results the user wants to receive
+func (database *Database) GetUserFinishedQuestionsSince(userId int64, sinceTime int64, count int) (questions []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT q.id FROM questions as q, users as u"+
+		" WHERE u.id=%d AND q.status=2 AND q.finish_time>%d AND (u.results_mode=%d OR (u.results_mode=%d AND"+
+		" (q.author=u.id OR q.id IN (SELECT question_id FROM answered_questions WHERE user_id=u.id))))"+
+		" ORDER BY q.id ASC LIMIT %d", userId, sinceTime, ResultsModeAll, ResultsModeAnswered, count))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var questionId int64
+		err := rows.Scan(&questionId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		questions = append(questions, questionId)
+	}
+
+	return
+}
+
+func (database *Database) GetAuthorOpenQuestionsCount(userId int64) (count int) {
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM questions WHERE author=%d AND status=1", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		err = rows.Err()
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Panic("No row found")
+	}
+
+	return
+}
+
+// GetAuthorCommitTimes returns times of questions commits of the user after the given time in ascending order
+func (database *Database) GetAuthorCommitTimes(userId int64, sinceTime int64) (commitTimes []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT commit_time FROM questions WHERE author=%d AND commit_time>%d ORDER BY commit_time ASC", userId, sinceTime))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commitTime int64
+		err := rows.Scan(&commitTime)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		commitTimes = append(commitTimes, commitTime)
+	}
+
+	return
+}
+
+func (database *Database) GetUserOpenQuestions(userId int64) (questions []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM questions WHERE author=%d AND status=1 ORDER BY id ASC", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var questionId int64
+		err := rows.Scan(&questionId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		questions = append(questions, questionId)
+	}
+
+	return
+}
+
+// RemoveUserAnswersFromOpenQuestions removes the answers of the user from the questions that are not
+// closed yet and returns the ids of these questions
+func (database *Database) RemoveUserAnswersFromOpenQuestions(userId int64) (questions []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT aq.question_id, aq.variant_index FROM answered_questions as aq"+
+		" INNER JOIN questions as q ON q.id=aq.question_id"+
+		" WHERE aq.user_id=%d AND q.status=1", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	var variantIndexes []sql.NullInt64
+	for rows.Next() {
+		var questionId int64
+		var variantIndex sql.NullInt64
+		err := rows.Scan(&questionId, &variantIndex)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		questions = append(questions, questionId)
+		variantIndexes = append(variantIndexes, variantIndex)
+	}
+	rows.Close()
+
+	for i, questionId := range questions {
+		// votes of the answers recorded before the variants were stored can't be found
+		if variantIndexes[i].Valid {
+			database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK variants SET votes_count=votes_count-1"+
+				" WHERE question_id=%d AND index_number=%d AND votes_count>0", questionId, variantIndexes[i].Int64))
+		}
+		database.execQuery(fmt.Sprintf("DELETE FROM answered_questions WHERE user_id=%d AND question_id=%d", userId, questionId))
+	}
+
+	database.execQuery(fmt.Sprintf("DELETE FROM ballot_ranks WHERE user_id=%d"+
+		" AND question_id IN (SELECT id FROM questions WHERE status=%d)", userId, QuestionStatusOpen))
+
+	return
+}
+
+func (database *Database) SetUserRole(userId int64, role int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET role=%d WHERE id=%d", role, userId))
+}
+
+func (database *Database) GetUserRole(userId int64) (role int) {
+	rows, err := database.query(fmt.Sprintf("SELECT role FROM users WHERE id=%d", userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&role)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
+// GetUsersWithRoles returns roles of all the users that are not regular users
+func (database *Database) GetUsersWithRoles() (roles map[int64]int) {
+	rows, err := database.query(fmt.Sprintf("SELECT id, role FROM users WHERE role<>%d", RoleUser))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	roles = make(map[int64]int)
+	for rows.Next() {
+		var userId int64
+		var role int
+		err := rows.Scan(&userId, &role)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		roles[userId] = role
+	}
+
+	return
+}
+
+func (database *Database) AddModerationLogRecord(moderatorId int64, action string, target string, reason string) {
+	database.execQuery(fmt.Sprintf("INSERT INTO moderation_log (time, moderator_id, action, target, reason) VALUES (%d,%d,'%s','%s','%s')",
+		time.Now().Unix(), moderatorId, sanitizeString(action), sanitizeString(target), sanitizeString(reason)))
+}
+
+// GetModerationLog returns the records starting from the newest ones
+func (database *Database) GetModerationLog(offset int, limit int) (records []ModerationLogRecord) {
+	rows, err := database.query(fmt.Sprintf("SELECT id, time, moderator_id, action, COALESCE(target, ''), COALESCE(reason, '')"+
+		" FROM moderation_log ORDER BY id DESC LIMIT %d OFFSET %d", limit, offset))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record ModerationLogRecord
+		err := rows.Scan(&record.Id, &record.Time, &record.ModeratorId, &record.Action, &record.Target, &record.Reason)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		records = append(records, record)
+	}
+
+	return
+}
+
+func (database *Database) GetModerationLogSize() (count int) {
+	rows, err := database.query("SELECT COUNT(*) FROM moderation_log")
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
+func (database *Database) GetQuestionStatus(questionId int64) (status int) {
+	rows, err := database.query(fmt.Sprintf("SELECT status FROM questions WHERE id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&status)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	} else {
+		status = -1
+	}
+
+	return
+}
+
+// AddQuestionReport stores the report, each user can report a question only once
+func (database *Database) AddQuestionReport(questionId int64, userId int64) {
+	database.execQuery(fmt.Sprintf("INSERT OR IGNORE INTO question_reports (question_id, user_id, time) VALUES (%d,%d,%d)",
+		questionId, userId, time.Now().Unix()))
+}
+
+func (database *Database) GetQuestionReportsCount(questionId int64) (count int) {
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM question_reports WHERE question_id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
+func (database *Database) ClearQuestionReports(questionId int64) {
+	database.execQuery(fmt.Sprintf("DELETE FROM question_reports WHERE question_id=%d", questionId))
+}
+
+func (database *Database) SuspendQuestion(questionId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=%d WHERE id=%d AND status=%d",
+		QuestionStatusSuspended, questionId, QuestionStatusOpen))
+	database.RemoveQuestionFromAllUsers(questionId)
+}
+
+// RestoreSuspendedQuestion opens the question again for the users that haven't answered
+// or reported it yet, so the reports should be cleared only after that
+func (database *Database) RestoreSuspendedQuestion(questionId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=%d WHERE id=%d AND status=%d",
+		QuestionStatusOpen, questionId, QuestionStatusSuspended))
+
+	database.returnQuestionToUsers(questionId)
+}
+
+// ReopenQuestion opens the closed question again for the users that haven't answered or reported it
+func (database *Database) ReopenQuestion(questionId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=%d, finish_time=NULL WHERE id=%d AND status=%d",
+		QuestionStatusOpen, questionId, QuestionStatusClosed))
+
+	database.returnQuestionToUsers(questionId)
+}
+
+func (database *Database) returnQuestionToUsers(questionId int64) {
+	database.execQuery(fmt.Sprintf("INSERT INTO pending_questions (user_id, question_id)"+
+		" SELECT u.id, %d FROM users as u"+
+		" LEFT JOIN answered_questions as aq ON aq.question_id=%d AND aq.user_id=u.id"+
+		" WHERE u.is_active=1 AND u.subscription=0 AND aq.user_id IS NULL"+
+		" AND u.id NOT IN (SELECT user_id FROM question_reports WHERE question_id=%d)", questionId, questionId, questionId))
+}
+
+func (database *Database) GetModeratorsChatIds() (chatIds []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT chat_id FROM users WHERE role>=%d AND is_active=1", RoleModerator))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatId int64
+		err := rows.Scan(&chatId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		chatIds = append(chatIds, chatId)
+	}
+
+	return
+}
+
+func (database *Database) SetUserNames(userId int64, displayName string, username string) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK users SET display_name='%s', username='%s' WHERE id=%d",
+		sanitizeString(displayName), sanitizeString(username), userId))
+}
+
+func (database *Database) SetQuestionPublic(questionId int64, isPublic bool) {
+	var value int
+	if isPublic {
+		value = 1
+	}
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET is_public=%d WHERE id=%d", value, questionId))
+}
+
+func (database *Database) IsQuestionPublic(questionId int64) (isPublic bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT is_public FROM questions WHERE id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var value int
+		err := rows.Scan(&value)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isPublic = (value != 0)
+	}
+
+	return
+}
+
+// MarkQuestionEdited marks that the question text was changed when it already had answers
+func (database *Database) MarkQuestionEdited(questionId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET is_edited=1 WHERE id=%d", questionId))
+}
+
+func (database *Database) IsQuestionEdited(questionId int64) (isEdited bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT is_edited FROM questions WHERE id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var value int
+		err := rows.Scan(&value)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		isEdited = (value != 0)
+	}
+
+	return
+}
+
+// GetQuestionVoters returns the users that answered the question with the variants they chose,
+// the answers recorded before the variants were stored are not returned
+func (database *Database) GetQuestionVoters(questionId int64) (voters []Voter) {
+	rows, err := database.query(fmt.Sprintf("SELECT aq.variant_index, COALESCE(u.display_name, ''), COALESCE(u.username, '')"+
+		" FROM answered_questions as aq INNER JOIN users as u ON u.id=aq.user_id"+
+		" WHERE aq.question_id=%d AND aq.variant_index IS NOT NULL ORDER BY aq.id ASC", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var voter Voter
+		err := rows.Scan(&voter.VariantIndex, &voter.DisplayName, &voter.Username)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		voters = append(voters, voter)
+	}
+
+	return
+}
+
+func (database *Database) SetQuestionResultsVisibility(questionId int64, visibility int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET results_visibility=%d WHERE id=%d", visibility, questionId))
+}
+
+func (database *Database) GetQuestionResultsVisibility(questionId int64) (visibility int) {
+	rows, err := database.query(fmt.Sprintf("SELECT results_visibility FROM questions WHERE id=%d", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&visibility)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
+func (database *Database) IsQuestionAnsweredByUser(userId int64, questionId int64) (isAnswered bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM answered_questions WHERE user_id=%d AND question_id=%d", userId, questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	isAnswered = rows.Next()
+	return
+}
+
+func (database *Database) GetQuestionPendingChatIds(questionId int64) (chatIds []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT u.chat_id FROM pending_questions as pq"+
+		" INNER JOIN users as u ON u.id=pq.user_id"+
+		" WHERE pq.question_id=%d AND u.is_active=1 ORDER BY u.id ASC", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatId int64
+		err := rows.Scan(&chatId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		chatIds = append(chatIds, chatId)
+	}
+
+	return
+}
+
+func (database *Database) AddQuestionTemplate(template QuestionTemplate, language string) {
+	database.execQuery(fmt.Sprintf("INSERT INTO question_templates (name, variants, min_votes, max_votes, duration, language) VALUES ('%s','%s',%d,%d,%d,'%s')",
+		sanitizeString(template.Name), sanitizeString(strings.Join(template.Variants, "\n")), template.MinAnswers, template.MaxAnswers, template.Hours, sanitizeString(language)))
+}
+
+func (database *Database) RemoveQuestionTemplate(templateId int64) {
+	database.execQuery(fmt.Sprintf("DELETE FROM question_templates WHERE id=%d", templateId))
+}
+
+func (database *Database) getQuestionTemplates(condition string) (templates []QuestionTemplate) {
+	rows, err := database.query("SELECT id, name, variants, min_votes, max_votes, duration FROM question_templates" +
+		" WHERE " + condition + " ORDER BY id ASC")
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var template QuestionTemplate
+		var variants string
+		err := rows.Scan(&template.Id, &template.Name, &variants, &template.MinAnswers, &template.MaxAnswers, &template.Hours)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		template.Variants = strings.Split(variants, "\n")
+		templates = append(templates, template)
+	}
+
+	return
+}
+
+// GetQuestionTemplates returns the templates added by moderators for the given language
+func (database *Database) GetQuestionTemplates(language string) []QuestionTemplate {
+	return database.getQuestionTemplates(fmt.Sprintf("language='%s'", sanitizeString(language)))
+}
+
+func (database *Database) GetQuestionTemplate(templateId int64) (template QuestionTemplate, ok bool) {
+	templates := database.getQuestionTemplates(fmt.Sprintf("id=%d", templateId))
+	if len(templates) > 0 {
+		return templates[0], true
+	}
+	return
+}
+
+// AddBallotRank adds the next preferred variant to the ballot of the user
+func (database *Database) AddBallotRank(questionId int64, userId int64, variantIndex int64) {
+	database.execQuery(fmt.Sprintf("INSERT INTO ballot_ranks (question_id, user_id, rank, variant_index)"+
+		" SELECT %d, %d, COUNT(*), %d FROM ballot_ranks WHERE question_id=%d AND user_id=%d",
+		questionId, userId, variantIndex, questionId, userId))
+}
+
+// GetUserBallot returns the variants the user ranked so far, starting from the most preferred
+func (database *Database) GetUserBallot(questionId int64, userId int64) (ballot []int) {
+	rows, err := database.query(fmt.Sprintf("SELECT variant_index FROM ballot_ranks"+
+		" WHERE question_id=%d AND user_id=%d ORDER BY rank ASC", questionId, userId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variantIndex int
+		err := rows.Scan(&variantIndex)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		ballot = append(ballot, variantIndex)
+	}
+
+	return
+}
+
+func (database *Database) ClearUserBallot(questionId int64, userId int64) {
+	database.execQuery(fmt.Sprintf("DELETE FROM ballot_ranks WHERE question_id=%d AND user_id=%d", questionId, userId))
+}
+
+// GetQuestionBallots returns the ballots of the users that finished ranking the variants
+func (database *Database) GetQuestionBallots(questionId int64) (ballots [][]int) {
+	rows, err := database.query(fmt.Sprintf("SELECT br.user_id, br.variant_index FROM ballot_ranks as br"+
+		" INNER JOIN answered_questions as aq ON aq.question_id=br.question_id AND aq.user_id=br.user_id"+
+		" WHERE br.question_id=%d ORDER BY br.user_id ASC, br.rank ASC", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	var lastUserId int64
+	for rows.Next() {
+		var userId int64
+		var variantIndex int
+		err := rows.Scan(&userId, &variantIndex)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		if len(ballots) == 0 || userId != lastUserId {
+			ballots = append(ballots, nil)
+			lastUserId = userId
+		}
+		ballots[len(ballots)-1] = append(ballots[len(ballots)-1], variantIndex)
+	}
+
+	return
+}
+
+// CreateSurvey makes the question being edited the first question of a new survey
+func (database *Database) CreateSurvey(questionId int64) (surveyId int64) {
+	author, err := database.GetAuthor(questionId)
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	database.execQuery(fmt.Sprintf("INSERT INTO surveys (author, status) VALUES (%d,%d)", author, SurveyStatusEditing))
+
+	rows, err := database.query(fmt.Sprintf("SELECT MAX(id) FROM surveys WHERE author=%d", author))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	if rows.Next() {
+		err := rows.Scan(&surveyId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+	rows.Close()
+
+	database.SetQuestionSurvey(questionId, surveyId, 0)
+	return
+}
+
+func (database *Database) SetQuestionSurvey(questionId int64, surveyId int64, position int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET survey_id=%d, survey_position=%d WHERE id=%d", surveyId, position, questionId))
+}
+
+// GetQuestionSurvey returns the survey the question belongs to, ok is false for standalone questions
+func (database *Database) GetQuestionSurvey(questionId int64) (surveyId int64, ok bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT survey_id FROM questions WHERE id=%d AND survey_id NOT NULL", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&surveyId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		ok = true
+	}
+
+	return
+}
+
+// ParkSurveyQuestion keeps the edited question of a survey until the whole survey is committed,
+// so the author can start editing the next one
+func (database *Database) ParkSurveyQuestion(questionId int64) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK questions SET status=%d WHERE id=%d AND status=%d", QuestionStatusSurveyDraft, questionId, QuestionStatusEditing))
+}
+
+// GetSurveyQuestions returns the questions of the survey in their order
+func (database *Database) GetSurveyQuestions(surveyId int64) (questions []int64) {
+	rows, err := database.query(fmt.Sprintf("SELECT id FROM questions WHERE survey_id=%d ORDER BY survey_position ASC", surveyId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var questionId int64
+		err := rows.Scan(&questionId)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		questions = append(questions, questionId)
+	}
+
+	return
+}
+
+// GetQuestionSurveyProgress returns the position of the question in its survey starting from one
+func (database *Database) GetQuestionSurveyProgress(questionId int64) (position int, count int, ok bool) {
+	rows, err := database.query(fmt.Sprintf("SELECT q.survey_position, (SELECT COUNT(*) FROM questions as sq WHERE sq.survey_id=q.survey_id)"+
+		" FROM questions as q WHERE q.id=%d AND q.survey_id NOT NULL", questionId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&position, &count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		return position + 1, count, true
+	}
+
+	return
+}
+
+// DiscardSurvey removes the survey that is being edited with all its questions
+func (database *Database) DiscardSurvey(surveyId int64) {
+	database.execQuery(fmt.Sprintf("DELETE FROM questions WHERE survey_id=%d AND (status=%d OR status=%d)", surveyId, QuestionStatusEditing, QuestionStatusSurveyDraft))
+	database.execQuery(fmt.Sprintf("DELETE FROM surveys WHERE id=%d AND status=%d", surveyId, SurveyStatusEditing))
+}
+
+func (database *Database) SetSurveyStatus(surveyId int64, status int) {
+	database.execQuery(fmt.Sprintf("UPDATE OR ROLLBACK surveys SET status=%d WHERE id=%d", status, surveyId))
+}
+
+func (database *Database) GetSurveyStatus(surveyId int64) (status int) {
+	status = -1
+	rows, err := database.query(fmt.Sprintf("SELECT status FROM surveys WHERE id=%d", surveyId))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&status)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+
+	return
+}
+
+// GetSurveyRespondentsCounts returns how many users answered the survey and have no its questions left,
+// and how many users answered some of its questions and still have others pending
+func (database *Database) GetSurveyRespondentsCounts(surveyId int64) (finished int, inProgress int) {
+	query := "SELECT COUNT(DISTINCT aq.user_id) FROM answered_questions as aq" +
+		" INNER JOIN questions as q ON q.id=aq.question_id" +
+		" WHERE q.survey_id=%d AND aq.user_id %s (SELECT pq.user_id FROM pending_questions as pq" +
+		" INNER JOIN questions as sq ON sq.id=pq.question_id WHERE sq.survey_id=%d)"
+
+	for _, condition := range []struct {
+		operator string
+		count    *int
+	}{{"NOT IN", &finished}, {"IN", &inProgress}} {
+		rows, err := database.query(fmt.Sprintf(query, surveyId, condition.operator, surveyId))
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+		if rows.Next() {
+			err := rows.Scan(condition.count)
+			if err != nil {
+				logger.Panic(err.Error())
+			}
+		}
+		rows.Close()
+	}
+
+	return
+}
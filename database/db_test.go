@@ -1,9 +1,11 @@
 package database
 
 import (
+	"database/sql"
 	"github.com/stretchr/testify/require"
 	"os"
 	"testing"
+	"time"
 )
 
 const (
@@ -412,10 +414,42 @@ func TestUserBans(t *testing.T) {
 
 	assert.False(db.IsUserBanned(userId1))
 
-	db.BanUser(userId1)
+	db.BanUser(userId1, "spam", 0)
 
 	assert.True(db.IsUserBanned(userId1))
 	assert.False(db.IsUserBanned(userId2))
+
+	now := time.Now().Unix()
+	db.BanUser(userId2, "", now+100)
+	assert.True(db.IsUserBanned(userId2))
+	assert.Equal(0, len(db.GetUsersWithExpiredBan(now)))
+
+	bans := db.GetBannedUsers()
+	assert.Equal(2, len(bans))
+	assert.Equal(userId1, bans[0].UserId)
+	assert.Equal(chatId1, bans[0].ChatId)
+	assert.Equal("spam", bans[0].Reason)
+	assert.Equal(int64(0), bans[0].BannedUntil)
+	assert.Equal(now+100, bans[1].BannedUntil)
+
+	db.BanUser(userId2, "", now-1)
+	assert.False(db.IsUserBanned(userId2))
+	expiredBans := db.GetUsersWithExpiredBan(now)
+	assert.Equal(1, len(expiredBans))
+	assert.Equal(userId2, expiredBans[0])
+
+	db.UnbanUser(userId1)
+	db.UnbanUser(userId2)
+	assert.False(db.IsUserBanned(userId1))
+	assert.Equal(0, len(db.GetBannedUsers()))
+
+	foundUserId, isFound := db.FindUserByChatId(chatId1)
+	assert.True(isFound)
+	assert.Equal(userId1, foundUserId)
+	_, isFound = db.FindUserByChatId(39)
+	assert.False(isFound)
+	assert.True(db.IsUserExists(userId1))
+	assert.False(db.IsUserExists(userId2 + 100))
 }
 
 func TestSanitizeString(t *testing.T) {
@@ -442,3 +476,993 @@ func TestSanitizeString(t *testing.T) {
 	variants := db.GetQuestionVariants(questionId)
 	assert.Equal(testText, variants[0])
 }
+
+func TestOutbox(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	db.AddOutboxMessages([]int64{10, 20}, "broadcast'text", 1, 100)
+	db.AddOutboxMessages([]int64{30}, "reply", 0, 100)
+	db.AddOutboxMessages([]int64{40}, "later", 0, 200)
+
+	{
+		messages := db.GetDueOutboxMessages(150, 10)
+		assert.Equal(3, len(messages))
+		if len(messages) == 3 {
+			// higher priority first
+			assert.Equal(int64(30), messages[0].ChatId)
+			assert.Equal(int64(10), messages[1].ChatId)
+			assert.Equal("broadcast'text", messages[1].Text)
+			assert.Equal(1, messages[1].Priority)
+			assert.Equal(0, messages[1].Attempts)
+		}
+	}
+
+	assert.Equal(2, len(db.GetDueOutboxMessages(150, 2)))
+
+	{
+		// the id of the second broadcast message, the reply was added after it
+		lastId := db.GetDueOutboxMessages(150, 10)[2].Id
+		newMessages := db.GetNewDueOutboxMessages(lastId, 250, 10)
+		assert.Equal(2, len(newMessages))
+		if len(newMessages) == 2 {
+			assert.Equal(int64(30), newMessages[0].ChatId)
+			assert.Equal(int64(40), newMessages[1].ChatId)
+			assert.Equal(0, len(db.GetNewDueOutboxMessages(newMessages[1].Id, 250, 10)))
+		}
+	}
+
+	messages := db.GetDueOutboxMessages(250, 10)
+	assert.Equal(4, len(messages))
+	if len(messages) != 4 {
+		return
+	}
+
+	db.MarkOutboxMessageDelivered(messages[0].Id, 150, 1)
+	db.MarkOutboxMessageFailed(messages[1].Id, "error")
+	db.RescheduleOutboxMessage(messages[2].Id, 300, "error")
+
+	assert.Equal(1, db.GetOutboxMessageStatus(messages[0].Id))
+	assert.Equal(2, db.GetOutboxMessageStatus(messages[1].Id))
+	assert.Equal(0, db.GetOutboxMessageStatus(messages[2].Id))
+
+	{
+		dueMessages := db.GetDueOutboxMessages(250, 10)
+		assert.Equal(1, len(dueMessages))
+	}
+
+	{
+		dueMessages := db.GetDueOutboxMessages(300, 10)
+		assert.Equal(2, len(dueMessages))
+		if len(dueMessages) == 2 {
+			assert.Equal(1, dueMessages[0].Attempts)
+		}
+	}
+
+	db.RemoveDeliveredOutboxMessages(200)
+	assert.Equal(2, len(db.GetDueOutboxMessages(300, 10)))
+
+	db.AddOutboxDocument(50, "log.csv", "id,'action'\n1,ban", 0, 300)
+	{
+		dueMessages := db.GetDueOutboxMessages(300, 10)
+		assert.Equal(3, len(dueMessages))
+		if len(dueMessages) == 3 {
+			// the reply has higher priority than the broadcasts
+			assert.Equal("log.csv", dueMessages[0].DocumentName)
+			assert.Equal("id,'action'\n1,ban", dueMessages[0].Text)
+			assert.Equal("", dueMessages[1].DocumentName)
+		}
+	}
+}
+
+func TestInactiveUsers(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	var chatId1 int64 = 31
+	var chatId2 int64 = 32
+	userId1 := db.GetUserId(chatId1)
+	userId2 := db.GetUserId(chatId2)
+
+	assert.True(db.IsUserActive(userId1))
+
+	db.StartCreatingQuestion(userId2)
+	questionId := db.GetUserEditingQuestion(userId2)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+	db.MarkUserReady(userId2)
+	db.AddOutboxMessages([]int64{chatId1}, "text", 1, 0)
+
+	assert.True(db.IsUserHasPendingQuestions(userId1))
+
+	db.MarkUserInactive(chatId1)
+
+	assert.False(db.IsUserActive(userId1))
+	assert.False(db.IsUserHasPendingQuestions(userId1))
+	assert.Equal([]int64{chatId2}, db.GetAllUsersChatIds())
+	assert.Equal([]int64{chatId2}, db.GetReadyUsersChatIds())
+
+	db.FailChatOutboxMessages(chatId1, "blocked")
+	assert.Equal(0, len(db.GetDueOutboxMessages(0, 10)))
+
+	db.ActivateUser(userId1)
+	assert.True(db.IsUserActive(userId1))
+	assert.Equal(2, len(db.GetAllUsersChatIds()))
+}
+
+func TestUpdaters(t *testing.T) {
+	assert := require.New(t)
+
+	{
+		updaters := makeUpdaters(minimalVersion, latestVersion)
+		assert.Equal(len(makeAllUpdaters()), len(updaters))
+	}
+
+	{
+		updaters := makeUpdaters("1.2", latestVersion)
+		assert.NotEmpty(updaters)
+		assert.Equal("1.3", updaters[0].version)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	var chatId1 int64 = 41
+	var chatId2 int64 = 42
+	var chatId3 int64 = 43
+	userId1 := db.GetUserId(chatId1)
+	userId2 := db.GetUserId(chatId2)
+	userId3 := db.GetUserId(chatId3)
+
+	db.StartCreatingQuestion(userId3)
+	questionId := db.GetUserEditingQuestion(userId3)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+	db.MarkUserReady(userId3)
+
+	assert.True(db.IsUserSubscribed(userId1))
+	assert.True(db.IsUserHasPendingQuestions(userId1))
+
+	db.StopUserSubscription(userId1)
+	db.PauseUserSubscription(userId2, 100)
+
+	assert.False(db.IsUserSubscribed(userId1))
+	assert.False(db.IsUserSubscribed(userId2))
+	assert.False(db.IsUserHasPendingQuestions(userId1))
+	assert.False(db.IsUserHasPendingQuestions(userId2))
+	assert.Equal([]int64{chatId3}, db.GetSubscribedUsersChatIds())
+	assert.Equal([]int64{chatId3}, db.GetReadyUsersChatIds())
+	assert.Equal(3, len(db.GetAllUsersChatIds()))
+
+	assert.Equal(0, len(db.GetUsersWithExpiredPause(99)))
+	assert.Equal([]int64{userId2}, db.GetUsersWithExpiredPause(100))
+
+	db.ResumeUserSubscription(userId2)
+	assert.True(db.IsUserSubscribed(userId2))
+	assert.Equal(0, len(db.GetUsersWithExpiredPause(100)))
+	assert.False(db.IsUserHasPendingQuestions(userId2))
+
+	db.InitNewUserQuestions(userId2)
+	assert.True(db.IsUserHasPendingQuestions(userId2))
+}
+
+func TestNotificationSettings(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	var chatId1 int64 = 51
+	var chatId2 int64 = 52
+	var chatId3 int64 = 53
+	userId1 := db.GetUserId(chatId1)
+	userId2 := db.GetUserId(chatId2)
+	userId3 := db.GetUserId(chatId3)
+
+	assert.Equal("", db.GetUserTimezone(userId1))
+	db.SetUserTimezone(userId1, "Europe/Berlin")
+	assert.Equal("Europe/Berlin", db.GetUserTimezone(userId1))
+
+	{
+		_, _, isSet := db.GetUserQuietHours(userId1)
+		assert.False(isSet)
+	}
+
+	db.SetUserQuietHours(userId1, 23*60, 8*60)
+
+	{
+		start, end, isSet := db.GetUserQuietHours(userId1)
+		assert.True(isSet)
+		assert.Equal(23*60, start)
+		assert.Equal(8*60, end)
+	}
+
+	{
+		quietHours := db.GetUsersQuietHours()
+		assert.Equal(1, len(quietHours))
+		assert.Equal(QuietHours{Timezone: "Europe/Berlin", Start: 23 * 60, End: 8 * 60}, quietHours[chatId1])
+	}
+
+	db.RemoveUserQuietHours(userId1)
+	assert.Equal(0, len(db.GetUsersQuietHours()))
+
+	db.StartCreatingQuestion(userId3)
+	questionId := db.GetUserEditingQuestion(userId3)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+
+	assert.Equal(ResultsModeAll, db.GetUserResultsMode(userId1))
+	db.SetUserResultsMode(userId1, ResultsModeAnswered)
+	db.SetUserResultsMode(userId2, ResultsModeNone)
+	db.SetUserResultsMode(userId3, ResultsModeAnswered)
+	assert.Equal(ResultsModeAnswered, db.GetUserResultsMode(userId1))
+
+	// only the author
+	assert.Equal([]int64{chatId3}, db.GetResultsRecipientsChatIds(questionId))
+
+	db.AddQuestionAnswer(questionId, userId1, 0)
+	db.AddQuestionAnswer(questionId, userId2, 0)
+
+	assert.Equal([]int64{chatId1, chatId3}, db.GetResultsRecipientsChatIds(questionId))
+}
+
+func TestDigest(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	var chatId1 int64 = 61
+	var chatId2 int64 = 62
+	userId1 := db.GetUserId(chatId1)
+	userId2 := db.GetUserId(chatId2)
+
+	assert.False(db.IsUserInDigestMode(userId1))
+
+	db.EnableUserDigest(userId1, 9*60, 100, 50)
+	db.MarkUserReady(userId2)
+
+	{
+		digestTime, isEnabled := db.GetUserDigestTime(userId1)
+		assert.True(isEnabled)
+		assert.Equal(9*60, digestTime)
+	}
+	assert.Equal(int64(50), db.GetUserLastDigestTime(userId1))
+	assert.Equal([]int64{chatId2}, db.GetReadyUsersChatIds())
+
+	assert.Equal(0, len(db.GetUsersWithDueDigest(99)))
+	assert.Equal([]int64{userId1}, db.GetUsersWithDueDigest(100))
+
+	db.SetUserDigestSent(userId1, 100, 200)
+	assert.Equal(0, len(db.GetUsersWithDueDigest(199)))
+	assert.Equal(int64(100), db.GetUserLastDigestTime(userId1))
+
+	db.StartCreatingQuestion(userId2)
+	questionId := db.GetUserEditingQuestion(userId2)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+
+	assert.Equal([]int64{questionId}, db.GetUserPendingQuestions(userId1))
+	assert.True(db.IsQuestionPendingForUser(userId1, questionId))
+	assert.False(db.IsQuestionPendingForUser(userId1, questionId+1))
+	// users in digest mode don't answer questions one by one
+	assert.Equal([]int64{userId2}, db.GetUsersAnsweringQuestionNow(questionId))
+
+	db.FinishQuestion(questionId)
+	assert.Equal([]int64{questionId}, db.GetUserFinishedQuestionsSince(userId1, 100, 10))
+	// users in digest mode receive results with the digest
+	assert.Equal([]int64{chatId2}, db.GetResultsRecipientsChatIds(questionId))
+
+	db.SetUserResultsMode(userId1, ResultsModeAnswered)
+	assert.Equal(0, len(db.GetUserFinishedQuestionsSince(userId1, 100, 10)))
+
+	db.DisableUserDigest(userId1)
+	assert.False(db.IsUserInDigestMode(userId1))
+}
+
+func TestQuestionsOrder(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	var chatId1 int64 = 71
+	var chatId2 int64 = 72
+	userId1 := db.GetUserId(chatId1)
+	userId2 := db.GetUserId(chatId2)
+
+	createQuestion := func(endTime int64) int64 {
+		db.StartCreatingQuestion(userId2)
+		questionId := db.GetUserEditingQuestion(userId2)
+		db.SetQuestionText(questionId, "text")
+		db.SetQuestionVariants(questionId, []string{"a", "b"})
+		db.SetQuestionRules(questionId, 1, 5, endTime)
+		db.CommitQuestion(questionId)
+		return questionId
+	}
+
+	now := time.Now().Unix()
+	questionId1 := createQuestion(now + 300)
+	questionId2 := createQuestion(now + 100)
+	questionId3 := createQuestion(now + 200)
+
+	db.AddQuestionAnswer(questionId1, userId2, 0)
+	db.AddQuestionAnswer(questionId2, userId2, 0)
+
+	assert.Equal(questionId1, db.GetUserNextQuestion(userId1))
+
+	db.SetQuestionsOrder(QuestionsOrderDeadline)
+	assert.Equal(questionId2, db.GetUserNextQuestion(userId1))
+
+	db.SetQuestionsOrder(QuestionsOrderFewestAnswers)
+	assert.Equal(questionId3, db.GetUserNextQuestion(userId1))
+	assert.Equal([]int64{userId1, userId2}, db.GetUsersAnsweringQuestionNow(questionId3))
+
+	// the question that was sent to the user stays the current one
+	db.SetUsersCurrentQuestion([]int64{chatId1}, questionId1)
+	assert.Equal(questionId1, db.GetUserNextQuestion(userId1))
+	assert.Equal([]int64{userId1}, db.GetUsersAnsweringQuestionNow(questionId1))
+	assert.Equal([]int64{userId2}, db.GetUsersAnsweringQuestionNow(questionId3))
+
+	db.RemoveUserPendingQuestion(userId1, questionId1)
+	assert.Equal(questionId3, db.GetUserNextQuestion(userId1))
+}
+
+func TestAuthorQuotas(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	userId := db.GetUserId(81)
+
+	startTime := time.Now().Unix() - 1
+
+	assert.Equal(0, db.GetAuthorOpenQuestionsCount(userId))
+	assert.Equal(0, len(db.GetAuthorCommitTimes(userId, startTime)))
+
+	for i := 0; i < 2; i++ {
+		db.StartCreatingQuestion(userId)
+		questionId := db.GetUserEditingQuestion(userId)
+		db.SetQuestionText(questionId, "text")
+		db.SetQuestionVariants(questionId, []string{"a", "b"})
+		db.SetQuestionRules(questionId, 1, 2, 0)
+		db.CommitQuestion(questionId)
+		if i == 0 {
+			db.FinishQuestion(questionId)
+		}
+	}
+
+	// editing question is not counted
+	db.StartCreatingQuestion(userId)
+
+	assert.Equal(1, db.GetAuthorOpenQuestionsCount(userId))
+	commitTimes := db.GetAuthorCommitTimes(userId, startTime)
+	assert.Equal(2, len(commitTimes))
+	assert.True(commitTimes[0] <= commitTimes[1])
+	assert.Equal(0, len(db.GetAuthorCommitTimes(userId, commitTimes[1])))
+}
+
+func TestRemoveUserAnswers(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(91)
+	userId1 := db.GetUserId(92)
+	userId2 := db.GetUserId(93)
+
+	var questions []int64
+	for i := 0; i < 2; i++ {
+		db.StartCreatingQuestion(authorId)
+		questionId := db.GetUserEditingQuestion(authorId)
+		db.SetQuestionText(questionId, "text")
+		db.SetQuestionVariants(questionId, []string{"a", "b"})
+		db.SetQuestionRules(questionId, 1, 5, 0)
+		db.CommitQuestion(questionId)
+		questions = append(questions, questionId)
+	}
+
+	db.AddQuestionAnswer(questions[0], userId1, 1)
+	db.AddQuestionAnswer(questions[0], userId2, 1)
+	db.AddQuestionAnswer(questions[1], userId1, 0)
+	db.FinishQuestion(questions[1])
+
+	assert.Equal([]int64{questions[0]}, db.GetUserOpenQuestions(authorId))
+	assert.Equal(0, len(db.GetUserOpenQuestions(userId1)))
+
+	affectedQuestions := db.RemoveUserAnswersFromOpenQuestions(userId1)
+	assert.Equal([]int64{questions[0]}, affectedQuestions)
+	assert.Equal([]int{0, 1}, db.GetQuestionAnswers(questions[0]))
+	assert.Equal(int64(1), int64(db.GetQuestionAnswersCount(questions[0])))
+
+	// closed questions are not changed
+	assert.Equal([]int{1, 0}, db.GetQuestionAnswers(questions[1]))
+	assert.Equal(0, len(db.RemoveUserAnswersFromOpenQuestions(userId1)))
+}
+
+func TestRoles(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	userId1 := db.GetUserId(101)
+	userId2 := db.GetUserId(102)
+	userId3 := db.GetUserId(103)
+
+	assert.Equal(RoleUser, db.GetUserRole(userId1))
+	assert.Equal(0, len(db.GetUsersWithRoles()))
+
+	db.SetUserRole(userId1, RoleAdmin)
+	db.SetUserRole(userId2, RoleModerator)
+
+	assert.Equal(RoleAdmin, db.GetUserRole(userId1))
+	assert.Equal(RoleModerator, db.GetUserRole(userId2))
+	assert.Equal(RoleUser, db.GetUserRole(userId3))
+	assert.Equal(map[int64]int{userId1: RoleAdmin, userId2: RoleModerator}, db.GetUsersWithRoles())
+
+	db.SetUserRole(userId2, RoleUser)
+	assert.Equal(RoleUser, db.GetUserRole(userId2))
+	assert.Equal(1, len(db.GetUsersWithRoles()))
+}
+
+func TestModerationLog(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	moderatorId := db.GetUserId(111)
+
+	assert.Equal(0, db.GetModerationLogSize())
+	assert.Equal(0, len(db.GetModerationLog(0, 10)))
+
+	db.AddModerationLogRecord(moderatorId, "ban", "u5", "it's spam")
+	db.AddModerationLogRecord(moderatorId, "rm", "q7", "")
+	db.AddModerationLogRecord(moderatorId, "unban", "u5", "")
+
+	assert.Equal(3, db.GetModerationLogSize())
+
+	records := db.GetModerationLog(0, 2)
+	assert.Equal(2, len(records))
+	assert.Equal("unban", records[0].Action)
+	assert.Equal("rm", records[1].Action)
+	assert.Equal("q7", records[1].Target)
+	assert.Equal(moderatorId, records[1].ModeratorId)
+	assert.NotEmpty(records[1].Time)
+
+	records = db.GetModerationLog(2, 2)
+	assert.Equal(1, len(records))
+	assert.Equal("ban", records[0].Action)
+	assert.Equal("u5", records[0].Target)
+	assert.Equal("it's spam", records[0].Reason)
+}
+
+func TestQuestionReports(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(121)
+	userId1 := db.GetUserId(122)
+	userId2 := db.GetUserId(123)
+	db.SetUserRole(userId2, RoleModerator)
+
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 5, 0)
+	db.CommitQuestion(questionId)
+	db.AddQuestionAnswer(questionId, userId2, 0)
+	db.RemoveUserPendingQuestion(userId2, questionId)
+
+	assert.Equal(QuestionStatusOpen, db.GetQuestionStatus(questionId))
+	assert.Equal(-1, db.GetQuestionStatus(questionId+100))
+	assert.Equal([]int64{121, 122}, db.GetQuestionPendingChatIds(questionId))
+	assert.Equal([]int64{123}, db.GetModeratorsChatIds())
+
+	db.AddQuestionReport(questionId, userId1)
+	db.AddQuestionReport(questionId, userId1)
+	db.AddQuestionReport(questionId, userId2)
+	assert.Equal(2, db.GetQuestionReportsCount(questionId))
+
+	db.SuspendQuestion(questionId)
+	assert.Equal(QuestionStatusSuspended, db.GetQuestionStatus(questionId))
+	assert.False(db.IsQuestionPendingForUser(userId1, questionId))
+	assert.Equal(0, len(db.GetActiveQuestions()))
+
+	db.RestoreSuspendedQuestion(questionId)
+	db.ClearQuestionReports(questionId)
+	assert.Equal(QuestionStatusOpen, db.GetQuestionStatus(questionId))
+	assert.Equal(0, db.GetQuestionReportsCount(questionId))
+	// the reporters don't receive the question again
+	assert.False(db.IsQuestionPendingForUser(userId1, questionId))
+	assert.True(db.IsQuestionPendingForUser(authorId, questionId))
+	// answered questions are not returned back
+	assert.False(db.IsQuestionPendingForUser(userId2, questionId))
+}
+
+func TestPublicQuestions(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(131)
+	userId1 := db.GetUserId(132)
+	userId2 := db.GetUserId(133)
+	db.SetUserNames(userId1, "John O'Brien", "john")
+	db.SetUserNames(userId2, "Jane", "")
+
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	assert.False(db.IsQuestionPublic(questionId))
+	db.SetQuestionPublic(questionId, true)
+	assert.True(db.IsQuestionPublic(questionId))
+
+	assert.Equal(0, len(db.GetQuestionVoters(questionId)))
+
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.AddQuestionAnswer(questionId, userId1, 1)
+	db.AddQuestionAnswer(questionId, userId2, 0)
+
+	voters := db.GetQuestionVoters(questionId)
+	assert.Equal([]Voter{
+		Voter{VariantIndex: 1, DisplayName: "John O'Brien", Username: "john"},
+		Voter{VariantIndex: 0, DisplayName: "Jane", Username: ""},
+	}, voters)
+
+	db.SetQuestionPublic(questionId, false)
+	assert.False(db.IsQuestionPublic(questionId))
+}
+
+func TestResultsVisibility(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(141)
+	userId := db.GetUserId(142)
+
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+
+	assert.Equal(ResultsVisibilityAtClose, db.GetQuestionResultsVisibility(questionId))
+	db.SetQuestionResultsVisibility(questionId, ResultsVisibilityAfterVote)
+	assert.Equal(ResultsVisibilityAfterVote, db.GetQuestionResultsVisibility(questionId))
+
+	assert.False(db.IsQuestionAnsweredByUser(userId, questionId))
+	db.AddQuestionAnswer(questionId, userId, 1)
+	assert.True(db.IsQuestionAnsweredByUser(userId, questionId))
+	assert.False(db.IsQuestionAnsweredByUser(authorId, questionId))
+}
+
+func TestEditingPublishedQuestion(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(41)
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+
+	assert.False(db.IsQuestionEdited(questionId))
+	db.MarkQuestionEdited(questionId)
+	assert.True(db.IsQuestionEdited(questionId))
+
+	db.AddOutboxQuestionMessages([]int64{42, 43}, questionId, "question", 1, 100)
+	db.AddOutboxMessages([]int64{44}, "other", 1, 100)
+
+	messages := db.GetDueOutboxMessages(100, 10)
+	assert.Equal(3, len(messages))
+	if len(messages) != 3 {
+		return
+	}
+	db.MarkOutboxMessageDelivered(messages[0].Id, 100, 501)
+	db.MarkOutboxMessageDelivered(messages[2].Id, 100, 503)
+
+	delivered := db.GetDeliveredQuestionMessages(questionId)
+	assert.Equal([]DeliveredMessage{{ChatId: 42, MessageId: 501}}, delivered)
+
+	db.UpdatePendingQuestionMessages(questionId, "new question")
+	db.AddOutboxEdits(delivered, "new question", 1, 100)
+
+	{
+		dueMessages := db.GetDueOutboxMessages(100, 10)
+		assert.Equal(2, len(dueMessages))
+		if len(dueMessages) == 2 {
+			assert.Equal(int64(43), dueMessages[0].ChatId)
+			assert.Equal("new question", dueMessages[0].Text)
+			assert.Equal(int64(0), dueMessages[0].EditMessageId)
+			assert.Equal(int64(42), dueMessages[1].ChatId)
+			assert.Equal("new question", dueMessages[1].Text)
+			assert.Equal(int64(501), dueMessages[1].EditMessageId)
+		}
+	}
+
+	// the messages of the open questions are kept
+	db.RemoveDeliveredOutboxMessages(200)
+	assert.Equal(1, len(db.GetDeliveredQuestionMessages(questionId)))
+
+	db.FinishQuestion(questionId)
+	db.RemoveDeliveredOutboxMessages(200)
+	assert.Equal(0, len(db.GetDeliveredQuestionMessages(questionId)))
+}
+
+func TestQuestionDuration(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(51)
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 24)
+
+	{
+		_, ok := db.GetQuestionDuration(questionId)
+		assert.False(ok)
+	}
+
+	db.CommitQuestion(questionId)
+	commitTime := time.Now().Unix()
+	db.SetQuestionRules(questionId, 1, 2, commitTime+24*3600+100)
+
+	{
+		hours, ok := db.GetQuestionDuration(questionId)
+		assert.True(ok)
+		assert.Equal(int64(24), hours)
+	}
+}
+
+func TestQuestionTemplates(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	db.AddQuestionTemplate(QuestionTemplate{
+		Name:       "it's a template",
+		Variants:   []string{"a", "b'c"},
+		MinAnswers: 1,
+		MaxAnswers: 10,
+		Hours:      24,
+	}, "en-us")
+	db.AddQuestionTemplate(QuestionTemplate{
+		Name:       "other",
+		Variants:   []string{"d"},
+		MinAnswers: 2,
+	}, "ru-ru")
+
+	templates := db.GetQuestionTemplates("en-us")
+	assert.Equal(1, len(templates))
+	if len(templates) != 1 {
+		return
+	}
+	assert.Equal("it's a template", templates[0].Name)
+	assert.Equal([]string{"a", "b'c"}, templates[0].Variants)
+	assert.Equal(1, templates[0].MinAnswers)
+	assert.Equal(10, templates[0].MaxAnswers)
+	assert.Equal(int64(24), templates[0].Hours)
+
+	{
+		template, ok := db.GetQuestionTemplate(templates[0].Id)
+		assert.True(ok)
+		assert.Equal(templates[0], template)
+	}
+
+	db.RemoveQuestionTemplate(templates[0].Id)
+	{
+		_, ok := db.GetQuestionTemplate(templates[0].Id)
+		assert.False(ok)
+	}
+	assert.Equal(0, len(db.GetQuestionTemplates("en-us")))
+	assert.Equal(1, len(db.GetQuestionTemplates("ru-ru")))
+}
+
+func TestScaleQuestions(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(61)
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+
+	assert.Equal(QuestionTypeChoice, db.GetQuestionType(questionId))
+
+	db.SetQuestionScale(questionId, 0, 3)
+	assert.Equal(QuestionTypeScale, db.GetQuestionType(questionId))
+	assert.Equal([]string{"0", "1", "2", "3"}, db.GetQuestionVariants(questionId))
+	assert.Equal([]int{0, 1, 2, 3}, db.GetQuestionVariantValues(questionId))
+
+	db.AddQuestionAnswer(questionId, db.GetUserId(62), 2)
+	assert.Equal([]int{0, 0, 1, 0}, db.GetQuestionAnswers(questionId))
+
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	assert.Equal(QuestionTypeChoice, db.GetQuestionType(questionId))
+	assert.Equal(0, len(db.GetQuestionVariantValues(questionId)))
+}
+
+func TestRankedBallots(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(71)
+	userId1 := db.GetUserId(72)
+	userId2 := db.GetUserId(73)
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionVariants(questionId, []string{"a", "b", "c"})
+	db.SetQuestionType(questionId, QuestionTypeRanked)
+	assert.Equal(QuestionTypeRanked, db.GetQuestionType(questionId))
+
+	db.AddBallotRank(questionId, userId1, 2)
+	db.AddBallotRank(questionId, userId1, 0)
+	db.AddBallotRank(questionId, userId2, 1)
+	assert.Equal([]int{2, 0}, db.GetUserBallot(questionId, userId1))
+
+	// only the finished ballots are counted
+	assert.Equal(0, len(db.GetQuestionBallots(questionId)))
+	db.AddQuestionAnswer(questionId, userId1, 2)
+	assert.Equal([][]int{{2, 0}}, db.GetQuestionBallots(questionId))
+	db.AddQuestionAnswer(questionId, userId2, 1)
+	assert.Equal([][]int{{2, 0}, {1}}, db.GetQuestionBallots(questionId))
+
+	db.ClearUserBallot(questionId, userId2)
+	assert.Equal(0, len(db.GetUserBallot(questionId, userId2)))
+
+	// the type stays when the variants are changed
+	db.SetQuestionVariants(questionId, []string{"d", "e"})
+	assert.Equal(QuestionTypeRanked, db.GetQuestionType(questionId))
+	assert.Equal(0, len(db.GetUserBallot(questionId, userId1)))
+}
+
+func TestSurveys(t *testing.T) {
+	assert := require.New(t)
+	db := createDbAndConnect(t)
+	defer clearDb()
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	authorId := db.GetUserId(81)
+	userId := db.GetUserId(82)
+
+	db.StartCreatingQuestion(authorId)
+	standaloneQuestionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(standaloneQuestionId, "standalone")
+	db.SetQuestionVariants(standaloneQuestionId, []string{"a", "b"})
+	db.SetQuestionRules(standaloneQuestionId, 1, 2, 0)
+	db.CommitQuestion(standaloneQuestionId)
+
+	db.StartCreatingQuestion(authorId)
+	questionId1 := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(questionId1, "first")
+	db.SetQuestionVariants(questionId1, []string{"a", "b"})
+	surveyId := db.CreateSurvey(questionId1)
+	db.ParkSurveyQuestion(questionId1)
+
+	// the parked question is not a draft anymore
+	assert.False(db.IsUserEditingQuestion(authorId))
+	db.StartCreatingQuestion(authorId)
+	questionId2 := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionSurvey(questionId2, surveyId, 1)
+	db.SetQuestionText(questionId2, "second")
+	db.SetQuestionVariants(questionId2, []string{"c", "d"})
+
+	assert.Equal([]int64{questionId1, questionId2}, db.GetSurveyQuestions(surveyId))
+	{
+		foundSurveyId, ok := db.GetQuestionSurvey(questionId2)
+		assert.True(ok)
+		assert.Equal(surveyId, foundSurveyId)
+		_, ok = db.GetQuestionSurvey(standaloneQuestionId)
+		assert.False(ok)
+	}
+	{
+		position, count, ok := db.GetQuestionSurveyProgress(questionId2)
+		assert.True(ok)
+		assert.Equal(2, position)
+		assert.Equal(2, count)
+	}
+
+	db.CommitQuestion(questionId1)
+	db.CommitQuestion(questionId2)
+	db.SetSurveyStatus(surveyId, SurveyStatusOpen)
+	assert.Equal(SurveyStatusOpen, db.GetSurveyStatus(surveyId))
+
+	// the survey that the user hasn't started doesn't go before the older questions
+	assert.Equal(standaloneQuestionId, db.GetUserNextQuestion(userId))
+	db.SetQuestionsOrder(QuestionsOrderFewestAnswers)
+	db.AddQuestionAnswer(standaloneQuestionId, authorId, 0)
+	assert.Equal(questionId1, db.GetUserNextQuestion(userId))
+	db.SetQuestionsOrder(QuestionsOrderOldest)
+
+	db.AddQuestionAnswer(questionId1, userId, 0)
+	db.RemoveUserPendingQuestion(userId, questionId1)
+	{
+		finished, inProgress := db.GetSurveyRespondentsCounts(surveyId)
+		assert.Equal(0, finished)
+		assert.Equal(1, inProgress)
+	}
+
+	// the started survey is continued before the other questions
+	assert.Equal(questionId2, db.GetUserNextQuestion(userId))
+
+	db.AddQuestionAnswer(questionId2, userId, 1)
+	db.RemoveUserPendingQuestion(userId, questionId2)
+	{
+		finished, inProgress := db.GetSurveyRespondentsCounts(surveyId)
+		assert.Equal(1, finished)
+		assert.Equal(0, inProgress)
+	}
+	assert.Equal(standaloneQuestionId, db.GetUserNextQuestion(userId))
+
+	db.StartCreatingQuestion(authorId)
+	questionId3 := db.GetUserEditingQuestion(authorId)
+	discardedSurveyId := db.CreateSurvey(questionId3)
+	db.ParkSurveyQuestion(questionId3)
+	db.StartCreatingQuestion(authorId)
+	db.SetQuestionSurvey(db.GetUserEditingQuestion(authorId), discardedSurveyId, 1)
+	db.DiscardSurvey(discardedSurveyId)
+	assert.False(db.IsUserEditingQuestion(authorId))
+	assert.Equal(0, len(db.GetSurveyQuestions(discardedSurveyId)))
+	assert.Equal(-1, db.GetSurveyStatus(discardedSurveyId))
+}
+
+func TestUpdatingBaselineDatabase(t *testing.T) {
+	assert := require.New(t)
+	clearDb()
+	defer clearDb()
+
+	// the schema of the 1.2 version before any of the later tables were added
+	conn, err := sql.Open("sqlite3", testDbPath)
+	assert.Nil(err)
+	for _, query := range []string{
+		"CREATE TABLE global_vars(name TEXT PRIMARY KEY,integer_value INTEGER,string_value STRING)",
+		"CREATE TABLE users(id INTEGER NOT NULL PRIMARY KEY,chat_id INTEGER UNIQUE NOT NULL,is_ready INTEGER NOT NULL,banned INTEGER)",
+		"CREATE UNIQUE INDEX chat_id_index ON users(chat_id)",
+		"CREATE TABLE questions(id INTEGER NOT NULL PRIMARY KEY,author INTEGER,text STRING,status INTEGER NOT NULL" +
+			",min_votes INTEGER,max_votes INTEGER,end_time INTEGER,FOREIGN KEY(author) REFERENCES users(id) ON DELETE SET NULL)",
+		"CREATE TABLE variants(id INTEGER NOT NULL PRIMARY KEY,question_id INTEGER NOT NULL,text STRING NOT NULL" +
+			",votes_count INTEGER NOT NULL,index_number INTEGER NOT NULL,FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE)",
+		"CREATE TABLE answered_questions(id INTEGER NOT NULL PRIMARY KEY,user_id INTEGER NOT NULL,question_id INTEGER NOT NULL" +
+			",FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE)",
+		"CREATE TABLE pending_questions(id INTEGER NOT NULL PRIMARY KEY,user_id INTEGER NOT NULL,question_id INTEGER NOT NULL" +
+			",FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,FOREIGN KEY(question_id) REFERENCES questions(id) ON DELETE CASCADE)",
+		"INSERT INTO global_vars (name, string_value) VALUES ('version', '1.2')",
+		"INSERT INTO users (chat_id, is_ready) VALUES (101, 1)",
+	} {
+		_, err := conn.Exec(query)
+		assert.Nil(err)
+	}
+	conn.Close()
+
+	db := connectDb(t)
+	if db == nil {
+		t.Fail()
+		return
+	}
+	defer db.Disconnect()
+
+	UpdateVersion(db)
+	assert.Equal(latestVersion, db.GetDatabaseVersion())
+
+	// the updated database works with the current schema
+	userId := db.GetUserId(101)
+	db.StartCreatingQuestion(userId)
+	questionId := db.GetUserEditingQuestion(userId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.SetQuestionRules(questionId, 1, 2, 0)
+	db.CommitQuestion(questionId)
+	db.AddOutboxQuestionMessages([]int64{101}, questionId, "text", 0, 0)
+	assert.Equal(1, len(db.GetDueOutboxMessages(0, 10)))
+}
@@ -1,8 +1,12 @@
 package database
 
+import (
+	"fmt"
+)
+
 const (
 	minimalVersion = "1.0"
-	latestVersion  = "1.2"
+	latestVersion  = "1.17"
 )
 
 type dbUpdater struct {
@@ -20,7 +24,31 @@ func UpdateVersion(db *Database) {
 			updater.updateDb(db)
 		}
 
-		db.SetDatabaseVersion(latestVersion)
+	}
+
+	// new databases don't have the version stored yet
+	db.SetDatabaseVersion(latestVersion)
+}
+
+// addColumnIfMissing is for the tables that were added after the baseline,
+// Connect creates them with all their columns before the updaters run
+func (database *Database) addColumnIfMissing(table string, column string, definition string) {
+	rows, err := database.query(fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name='%s'", table, column))
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	var count int
+	if rows.Next() {
+		err := rows.Scan(&count)
+		if err != nil {
+			logger.Panic(err.Error())
+		}
+	}
+	rows.Close()
+
+	if count == 0 {
+		database.execQuery(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	}
 }
 
@@ -36,8 +64,8 @@ func makeUpdaters(versionFrom string, versionTo string) (updaters []dbUpdater) {
 			}
 		} else {
 			if updater.version == versionFrom {
+				// the database already has this version, start from the next one
 				isFirstFound = true
-				updaters = append(updaters, updater)
 			}
 		}
 	}
@@ -53,6 +81,112 @@ func makeAllUpdaters() (updaters []dbUpdater) {
 				db.execQuery("ALTER TABLE users ADD COLUMN banned")
 			},
 		},
+		dbUpdater{
+			version: "1.3",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN is_active INTEGER NOT NULL DEFAULT 1")
+			},
+		},
+		dbUpdater{
+			version: "1.4",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN subscription INTEGER NOT NULL DEFAULT 0")
+				db.execQuery("ALTER TABLE users ADD COLUMN paused_until INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.5",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN timezone STRING")
+				db.execQuery("ALTER TABLE users ADD COLUMN quiet_start INTEGER")
+				db.execQuery("ALTER TABLE users ADD COLUMN quiet_end INTEGER")
+				db.execQuery("ALTER TABLE users ADD COLUMN results_mode INTEGER NOT NULL DEFAULT 0")
+			},
+		},
+		dbUpdater{
+			version: "1.6",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN digest_time INTEGER")
+				db.execQuery("ALTER TABLE users ADD COLUMN next_digest_time INTEGER")
+				db.execQuery("ALTER TABLE users ADD COLUMN last_digest_time INTEGER")
+				db.execQuery("ALTER TABLE questions ADD COLUMN finish_time INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.7",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN current_question INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.8",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE questions ADD COLUMN commit_time INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.9",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN ban_reason STRING")
+				db.execQuery("ALTER TABLE users ADD COLUMN banned_until INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.10",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE answered_questions ADD COLUMN variant_index INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.11",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN role INTEGER NOT NULL DEFAULT 0")
+			},
+		},
+		dbUpdater{
+			version: "1.12",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE users ADD COLUMN display_name STRING")
+				db.execQuery("ALTER TABLE users ADD COLUMN username STRING")
+				db.execQuery("ALTER TABLE questions ADD COLUMN is_public INTEGER NOT NULL DEFAULT 0")
+			},
+		},
+		dbUpdater{
+			version: "1.13",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE questions ADD COLUMN results_visibility INTEGER NOT NULL DEFAULT 0")
+			},
+		},
+		dbUpdater{
+			version: "1.14",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE questions ADD COLUMN is_edited INTEGER NOT NULL DEFAULT 0")
+				// Connect already creates these columns when the database had no outbox before the update
+				db.addColumnIfMissing("outbox", "question_id", "INTEGER")
+				db.addColumnIfMissing("outbox", "message_id", "INTEGER")
+				db.addColumnIfMissing("outbox", "edit_message_id", "INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.15",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE questions ADD COLUMN question_type INTEGER NOT NULL DEFAULT 0")
+				db.execQuery("ALTER TABLE variants ADD COLUMN value INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.16",
+			updateDb: func(db *Database) {
+				db.execQuery("ALTER TABLE questions ADD COLUMN survey_id INTEGER")
+				db.execQuery("ALTER TABLE questions ADD COLUMN survey_position INTEGER")
+			},
+		},
+		dbUpdater{
+			version: "1.17",
+			updateDb: func(db *Database) {
+				db.addColumnIfMissing("outbox", "document_name", "STRING")
+			},
+		},
 	}
 	return
 }
@@ -6,7 +6,8 @@ type Variant struct {
 }
 
 type Dialog struct {
-	Id       string
-	Text     string
-	Variants []Variant
+	Id           string
+	AdditionalId int64 // id of the object the dialog is about, 0 if there is none
+	Text         string
+	Variants     []Variant
 }
@@ -0,0 +1,276 @@
+package dialogFactories
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/processing"
+	"github.com/nicksnyder/go-i18n/i18n"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	deadlineExtension = 24 * time.Hour
+	maxVotesStep      = 10
+)
+
+// MakeAuthorQuestionDialogFactory creates the dialog for the author to control their open question
+// or to reopen the closed one, the additional id is the id of the question
+func MakeAuthorQuestionDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
+	var factory *DialogFactory
+	// the variants that change the question show the updated dialog
+	sendDialog := func(questionId int64, data *processing.ProcessData) {
+		data.Static.Chat.SendDialog(factory.MakeDialog(questionId, data), data.ChatId)
+	}
+
+	factory = &(DialogFactory{
+		getTextFn: getAuthorQuestionText,
+		variants: []variantPrototype{
+			variantPrototype{
+				id:         "et",
+				text:       trans("author_commands_edit_text"),
+				isActiveFn: isQuestionOpen,
+				process:    editPublishedTextCommand,
+			},
+			variantPrototype{
+				id:   "ev",
+				text: trans("author_commands_edit_variants"),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					return isQuestionOpen(questionId, data) && data.Static.Db.GetQuestionAnswersCount(questionId) == 0
+				},
+				process: editPublishedVariantsCommand,
+			},
+			variantPrototype{
+				id:         "cl",
+				text:       trans("author_commands_close"),
+				isActiveFn: isQuestionOpen,
+				process:    closeQuestionCommand,
+			},
+			variantPrototype{
+				id:   "ex",
+				text: trans("author_commands_extend"),
+				// the questions of a survey share the rules
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					return isQuestionOpen(questionId, data) && !processing.IsQuestionInSurvey(data.Static, questionId)
+				},
+				process: func(questionId int64, data *processing.ProcessData) {
+					extendQuestion(questionId, data, sendDialog)
+				},
+			},
+			variantPrototype{
+				id:   "mu",
+				text: trans("author_commands_raise_max", maxVotesStep),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					_, maxAnswers, _ := data.Static.Db.GetQuestionRules(questionId)
+					return isQuestionOpen(questionId, data) && maxAnswers > 0 && !processing.IsQuestionInSurvey(data.Static, questionId)
+				},
+				process: func(questionId int64, data *processing.ProcessData) {
+					changeMaxAnswers(questionId, data, maxVotesStep, sendDialog)
+				},
+			},
+			variantPrototype{
+				id:   "md",
+				text: trans("author_commands_lower_max", maxVotesStep),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					_, maxAnswers, _ := data.Static.Db.GetQuestionRules(questionId)
+					return isQuestionOpen(questionId, data) && maxAnswers > maxVotesStep && !processing.IsQuestionInSurvey(data.Static, questionId)
+				},
+				process: func(questionId int64, data *processing.ProcessData) {
+					changeMaxAnswers(questionId, data, -maxVotesStep, sendDialog)
+				},
+			},
+			variantPrototype{
+				id:   "ro",
+				text: trans("author_commands_reopen"),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					return data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusClosed &&
+						!processing.IsQuestionInSurvey(data.Static, questionId)
+				},
+				process: func(questionId int64, data *processing.ProcessData) {
+					reopenQuestionCommand(questionId, data, sendDialog)
+				},
+			},
+		},
+	})
+	return factory
+}
+
+func getAuthorQuestionText(questionId int64, data *processing.ProcessData) string {
+	if data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusClosed {
+		return fmt.Sprintf("%s\n/reuse%d - %s",
+			processing.GetResultsText(data.Static, questionId),
+			questionId,
+			data.Static.Trans("reuse_question_command"),
+		)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<i>" + data.Static.Db.GetQuestionText(questionId) + "</i>\n")
+	buffer.WriteString(data.Static.Trans("answers", data.Static.Db.GetQuestionAnswersCount(questionId)) + "\n")
+	buffer.WriteString(processing.GetDificientDataForQuestionText(data.Static, questionId))
+	return buffer.String()
+}
+
+func isQuestionOpen(questionId int64, data *processing.ProcessData) bool {
+	return data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusOpen
+}
+
+// isAuthorControlAllowed checks that the user is the author of the question and it's still open
+func isAuthorControlAllowed(questionId int64, data *processing.ProcessData) bool {
+	author, err := data.Static.Db.GetAuthor(questionId)
+	if err != nil || author != data.UserId {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
+		return false
+	}
+
+	if data.Static.Db.GetQuestionStatus(questionId) != database.QuestionStatusOpen {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_outdated"))
+		return false
+	}
+
+	return true
+}
+
+// notifyRulesChanged lets the users that haven't answered the question yet know about the new rules
+func notifyRulesChanged(questionId int64, data *processing.ProcessData) {
+	chatIds := data.Static.Db.GetQuestionPendingChatIds(questionId)
+	data.Static.Chat.BroadcastMessage(chatIds, data.Static.Trans("say_question_rules_changed", map[string]interface{}{
+		"Text":  data.Static.Db.GetQuestionText(questionId),
+		"Rules": processing.GetDificientDataForQuestionText(data.Static, questionId),
+	}))
+}
+
+func editPublishedTextCommand(questionId int64, data *processing.ProcessData) {
+	if !isAuthorControlAllowed(questionId, data) {
+		return
+	}
+
+	data.Static.UserStates[data.ChatId] = processing.WaitingPublishedText
+	data.Static.EditedQuestions[data.ChatId] = questionId
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_question_text"))
+}
+
+func editPublishedVariantsCommand(questionId int64, data *processing.ProcessData) {
+	if !isAuthorControlAllowed(questionId, data) {
+		return
+	}
+
+	// changing the variants would break the answers that are already given
+	if data.Static.Db.GetQuestionAnswersCount(questionId) > 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_has_answers"))
+		return
+	}
+
+	data.Static.UserStates[data.ChatId] = processing.WaitingPublishedVariants
+	data.Static.EditedQuestions[data.ChatId] = questionId
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_variants"))
+}
+
+func closeQuestionCommand(questionId int64, data *processing.ProcessData) {
+	if !isAuthorControlAllowed(questionId, data) {
+		return
+	}
+
+	data.Log.WithField("question_id", questionId).Info("Question closed by the author")
+	processing.CompleteQuestion(data.Static, questionId)
+}
+
+func extendQuestion(questionId int64, data *processing.ProcessData, sendDialog func(int64, *processing.ProcessData)) {
+	if !isAuthorControlAllowed(questionId, data) {
+		return
+	}
+
+	if processing.IsQuestionInSurvey(data.Static, questionId) {
+		return
+	}
+
+	minAnswers, maxAnswers, endTime := data.Static.Db.GetQuestionRules(questionId)
+
+	newEndTime := time.Unix(endTime, 0)
+	if now := time.Now(); newEndTime.Before(now) {
+		newEndTime = now
+	}
+	newEndTime = newEndTime.Add(deadlineExtension)
+
+	data.Static.Db.SetQuestionRules(questionId, minAnswers, maxAnswers, newEndTime.Unix())
+	data.Static.Timers[questionId] = newEndTime
+	data.Log.WithFields(logrus.Fields{
+		"question_id": questionId,
+		"end_time":    newEndTime.Unix(),
+	}).Info("Question deadline extended")
+
+	notifyRulesChanged(questionId, data)
+	sendDialog(questionId, data)
+}
+
+func changeMaxAnswers(questionId int64, data *processing.ProcessData, delta int, sendDialog func(int64, *processing.ProcessData)) {
+	if !isAuthorControlAllowed(questionId, data) {
+		return
+	}
+
+	minAnswers, maxAnswers, endTime := data.Static.Db.GetQuestionRules(questionId)
+	if maxAnswers == 0 || maxAnswers+delta <= 0 || processing.IsQuestionInSurvey(data.Static, questionId) {
+		return
+	}
+
+	data.Static.Db.SetQuestionRules(questionId, minAnswers, maxAnswers+delta, endTime)
+	data.Log.WithFields(logrus.Fields{
+		"question_id": questionId,
+		"max_votes":   maxAnswers + delta,
+	}).Info("Question max votes changed")
+
+	// lowering the limit can complete the question right away
+	if processing.ProcessCompleteness(data.Static, questionId) {
+		return
+	}
+
+	notifyRulesChanged(questionId, data)
+	sendDialog(questionId, data)
+}
+
+func reopenQuestionCommand(questionId int64, data *processing.ProcessData, sendDialog func(int64, *processing.ProcessData)) {
+	author, err := data.Static.Db.GetAuthor(questionId)
+	if err != nil || author != data.UserId {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
+		return
+	}
+
+	// the questions of a survey are completed together
+	if data.Static.Db.GetQuestionStatus(questionId) != database.QuestionStatusClosed || processing.IsQuestionInSurvey(data.Static, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_reopenable"))
+		return
+	}
+
+	if data.Static.Db.IsUserBanned(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_youre_banned"))
+		return
+	}
+
+	if quotaWarning := processing.GetQuestionQuotaWarning(data); quotaWarning != "" {
+		data.Static.Chat.SendMessage(data.ChatId, quotaWarning)
+		return
+	}
+
+	minAnswers, maxAnswers, _ := data.Static.Db.GetQuestionRules(questionId)
+	// the question that reached the limit would be completed again right away
+	isMaxAnswersRaised := false
+	if answersCount := data.Static.Db.GetQuestionAnswersCount(questionId); maxAnswers > 0 && answersCount >= maxAnswers {
+		maxAnswers = answersCount + maxVotesStep
+		isMaxAnswersRaised = true
+	}
+	endTime := time.Now().Add(deadlineExtension)
+
+	processing.ReopenQuestion(data.Static, questionId, minAnswers, maxAnswers, endTime)
+	data.Log.WithFields(logrus.Fields{
+		"question_id": questionId,
+		"end_time":    endTime.Unix(),
+		"max_votes":   maxAnswers,
+	}).Info("Question reopened by the author")
+
+	notifyRulesChanged(questionId, data)
+	if isMaxAnswersRaised {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_max_answers_raised", maxAnswers))
+	}
+	sendDialog(questionId, data)
+}
@@ -0,0 +1,170 @@
+package dialogFactories
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/dialog"
+	"github.com/gameraccoon/telegram-poll-bot/processing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testDbPath = "./testDb.db"
+)
+
+type testChat struct {
+	messages []string
+}
+
+func (chat *testChat) SendMessage(chatId int64, message string) {
+	chat.messages = append(chat.messages, message)
+}
+
+func (chat *testChat) BroadcastMessage(chatIds []int64, message string) {
+	chat.messages = append(chat.messages, message)
+}
+
+func (chat *testChat) SendQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+}
+
+func (chat *testChat) BroadcastQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+}
+
+func (chat *testChat) UpdateQuestion(db *database.Database, questionId int64) {
+}
+
+func (chat *testChat) SendDialog(dialog *dialog.Dialog, chatId int64) {
+}
+
+func (chat *testChat) SendDocument(chatId int64, fileName string, content []byte) {
+}
+
+func (chat *testChat) lastMessage() string {
+	if len(chat.messages) == 0 {
+		return ""
+	}
+	return chat.messages[len(chat.messages)-1]
+}
+
+func makeTestProcessData(db *database.Database, chat *testChat, chatId int64) *processing.ProcessData {
+	return &processing.ProcessData{
+		Static: &processing.StaticProccessStructs{
+			Chat:            chat,
+			Db:              db,
+			UserStates:      make(map[int64]processing.UserState),
+			Timers:          make(map[int64]time.Time),
+			Config:          &processing.StaticConfiguration{},
+			Trans:           func(translationID string, args ...interface{}) string { return translationID },
+			EditedQuestions: make(map[int64]int64),
+		},
+		ChatId: chatId,
+		UserId: db.GetUserId(chatId),
+		Log:    logrus.WithField("chat_id", chatId),
+	}
+}
+
+func TestAuthorQuestionControls(t *testing.T) {
+	assert := require.New(t)
+	os.Remove(testDbPath)
+	defer os.Remove(testDbPath)
+
+	db := &database.Database{}
+	err := db.Connect(testDbPath)
+	if err != nil {
+		assert.Fail("Problem with creation db connection:" + err.Error())
+		return
+	}
+	defer db.Disconnect()
+
+	chat := &testChat{}
+	data := makeTestProcessData(db, chat, 121)
+	userId1 := db.GetUserId(122)
+	userId2 := db.GetUserId(123)
+	userId3 := db.GetUserId(124)
+
+	dialogsCount := 0
+	sendDialog := func(questionId int64, data *processing.ProcessData) {
+		dialogsCount++
+	}
+
+	endTime := time.Now().Add(time.Hour)
+	db.StartCreatingQuestion(data.UserId)
+	questionId := db.GetUserEditingQuestion(data.UserId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.CommitQuestion(questionId)
+	db.SetQuestionRules(questionId, 1, 12, endTime.Unix())
+	data.Static.Timers[questionId] = endTime
+	assert.Equal([]int64{121, 122, 123, 124}, db.GetQuestionPendingChatIds(questionId))
+
+	// only the author controls the question
+	otherUserData := makeTestProcessData(db, chat, 122)
+	otherUserData.Static = data.Static
+	extendQuestion(questionId, otherUserData, sendDialog)
+	assert.Equal("warn_unknown_command", chat.lastMessage())
+	_, _, newEndTime := db.GetQuestionRules(questionId)
+	assert.Equal(endTime.Unix(), newEndTime)
+
+	extendQuestion(questionId, data, sendDialog)
+	_, _, newEndTime = db.GetQuestionRules(questionId)
+	assert.Equal(endTime.Add(deadlineExtension).Unix(), newEndTime)
+	assert.Equal(newEndTime, data.Static.Timers[questionId].Unix())
+	assert.Equal("say_question_rules_changed", chat.lastMessage())
+	assert.Equal(1, dialogsCount)
+
+	changeMaxAnswers(questionId, data, maxVotesStep, sendDialog)
+	_, maxAnswers, _ := db.GetQuestionRules(questionId)
+	assert.Equal(22, maxAnswers)
+	changeMaxAnswers(questionId, data, -maxVotesStep, sendDialog)
+	_, maxAnswers, _ = db.GetQuestionRules(questionId)
+	assert.Equal(12, maxAnswers)
+	assert.Equal(3, dialogsCount)
+
+	db.AddQuestionAnswer(questionId, userId1, 0)
+	db.RemoveUserPendingQuestion(userId1, questionId)
+	db.AddQuestionAnswer(questionId, userId2, 1)
+	db.RemoveUserPendingQuestion(userId2, questionId)
+
+	// lowering the limit below the count of answers completes the question
+	changeMaxAnswers(questionId, data, -maxVotesStep, sendDialog)
+	assert.Equal(database.QuestionStatusClosed, db.GetQuestionStatus(questionId))
+	assert.False(db.IsQuestionPendingForUser(userId3, questionId))
+	assert.Equal(3, dialogsCount)
+
+	extendQuestion(questionId, data, sendDialog)
+	assert.Equal("say_question_outdated", chat.lastMessage())
+	assert.Equal(3, dialogsCount)
+
+	// the reopened question gets a new deadline and room for more answers
+	messagesCount := len(chat.messages)
+	reopenQuestionCommand(questionId, data, sendDialog)
+	assert.Equal([]string{"say_question_rules_changed", "say_max_answers_raised"}, chat.messages[messagesCount:])
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId))
+	_, maxAnswers, newEndTime = db.GetQuestionRules(questionId)
+	assert.Equal(2+maxVotesStep, maxAnswers)
+	assert.True(newEndTime > time.Now().Unix())
+	assert.Equal(newEndTime, data.Static.Timers[questionId].Unix())
+	assert.True(db.IsQuestionPendingForUser(userId3, questionId))
+	assert.False(db.IsQuestionPendingForUser(userId1, questionId))
+	assert.False(db.IsQuestionPendingForUser(userId2, questionId))
+	assert.Equal([]int64{121, 124}, db.GetQuestionPendingChatIds(questionId))
+	assert.Equal(4, dialogsCount)
+
+	reopenQuestionCommand(questionId, data, sendDialog)
+	assert.Equal("warn_question_not_reopenable", chat.lastMessage())
+	assert.Equal(4, dialogsCount)
+
+	closeQuestionCommand(questionId, otherUserData)
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId))
+
+	closeQuestionCommand(questionId, data)
+	assert.Equal(database.QuestionStatusClosed, db.GetQuestionStatus(questionId))
+	assert.False(db.IsQuestionPendingForUser(userId3, questionId))
+	_, ok := data.Static.Timers[questionId]
+	assert.False(ok)
+	assert.True(strings.HasPrefix(chat.lastMessage(), "results_header"))
+}
@@ -5,48 +5,50 @@ import (
 	"github.com/gameraccoon/telegram-poll-bot/processing"
 )
 
+// all the functions receive the additional id of the dialog, 0 if the dialog isn't bound to an object
 type variantPrototype struct {
 	id   string
 	text string
 	// nil if the variant is always active
-	isActiveFn func(data *processing.ProcessData) bool
-	process    func(data *processing.ProcessData)
+	isActiveFn func(additionalId int64, data *processing.ProcessData) bool
+	process    func(additionalId int64, data *processing.ProcessData)
 }
 
 type DialogFactory struct {
 	id        string
-	getTextFn func(data *processing.ProcessData) string
+	getTextFn func(additionalId int64, data *processing.ProcessData) string
 	variants  []variantPrototype
 }
 
-func (dialogFactory *DialogFactory) MakeDialog(data *processing.ProcessData) *dialog.Dialog {
+func (dialogFactory *DialogFactory) MakeDialog(additionalId int64, data *processing.ProcessData) *dialog.Dialog {
 	dialog := dialog.Dialog{
-		Id:       dialogFactory.id,
-		Text:     dialogFactory.getText(data),
-		Variants: dialogFactory.getVariants(data),
+		Id:           dialogFactory.id,
+		AdditionalId: additionalId,
+		Text:         dialogFactory.getText(additionalId, data),
+		Variants:     dialogFactory.getVariants(additionalId, data),
 	}
 	return &dialog
 }
 
-func (dialog *DialogFactory) ProcessVariant(id string, data *processing.ProcessData) {
+func (dialog *DialogFactory) ProcessVariant(id string, additionalId int64, data *processing.ProcessData) {
 	for _, variant := range dialog.variants {
 		if variant.id == id {
-			variant.process(data)
+			variant.process(additionalId, data)
 		}
 	}
 }
 
-func (dialogFactory *DialogFactory) getText(data *processing.ProcessData) string {
+func (dialogFactory *DialogFactory) getText(additionalId int64, data *processing.ProcessData) string {
 	if dialogFactory.getTextFn != nil {
-		return dialogFactory.getTextFn(data)
+		return dialogFactory.getTextFn(additionalId, data)
 	} else {
 		return ""
 	}
 }
 
-func (dialogFactory *DialogFactory) getVariants(data *processing.ProcessData) (variants []dialog.Variant) {
+func (dialogFactory *DialogFactory) getVariants(additionalId int64, data *processing.ProcessData) (variants []dialog.Variant) {
 	for _, variant := range dialogFactory.variants {
-		if variant.isActive(data) {
+		if variant.isActive(additionalId, data) {
 			if variants == nil {
 				variants = make([]dialog.Variant, 0)
 			}
@@ -60,9 +62,9 @@ func (dialogFactory *DialogFactory) getVariants(data *processing.ProcessData) (v
 	return
 }
 
-func (variant *variantPrototype) isActive(data *processing.ProcessData) bool {
+func (variant *variantPrototype) isActive(additionalId int64, data *processing.ProcessData) bool {
 	if variant.isActiveFn != nil {
-		return variant.isActiveFn(data)
+		return variant.isActiveFn(additionalId, data)
 	}
 
 	// return true because isActiveFn hasn't set
@@ -18,18 +18,19 @@ func (dialogManager *DialogManager) RegisterDialogFactory(id string, dialogFacto
 	dialogFactory.id = id
 }
 
-func (dialogManager *DialogManager) MakeDialog(dialogId string, data *processing.ProcessData) (dialog *dialog.Dialog) {
+// MakeDialog creates a dialog, additionalId is the id of the object the dialog is about or 0
+func (dialogManager *DialogManager) MakeDialog(dialogId string, additionalId int64, data *processing.ProcessData) (dialog *dialog.Dialog) {
 	factory := dialogManager.getDialogFactory(dialogId)
 	if factory != nil {
-		dialog = factory.MakeDialog(data)
+		dialog = factory.MakeDialog(additionalId, data)
 	}
 	return
 }
 
-func (dialogManager *DialogManager) ProcessVariant(dialogId string, variantId string, data *processing.ProcessData) (processed bool) {
+func (dialogManager *DialogManager) ProcessVariant(dialogId string, variantId string, additionalId int64, data *processing.ProcessData) (processed bool) {
 	factory := dialogManager.getDialogFactory(dialogId)
 	if factory != nil {
-		factory.ProcessVariant(variantId, data)
+		factory.ProcessVariant(variantId, additionalId, data)
 		processed = true
 	}
 	return
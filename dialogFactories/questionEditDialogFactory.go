@@ -3,12 +3,19 @@ package dialogFactories
 import (
 	"bytes"
 	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/database"
 	"github.com/gameraccoon/telegram-poll-bot/processing"
 	"github.com/nicksnyder/go-i18n/i18n"
 )
 
 func MakeQuestionEditDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
-	return &(DialogFactory{
+	var factory *DialogFactory
+	// the variants that change the question show the updated guide
+	sendGuide := func(data *processing.ProcessData) {
+		data.Static.Chat.SendDialog(factory.MakeDialog(0, data), data.ChatId)
+	}
+
+	factory = &(DialogFactory{
 		getTextFn: getEditingGuide,
 		variants: []variantPrototype{
 			variantPrototype{
@@ -23,18 +30,89 @@ func MakeQuestionEditDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
 				isActiveFn: nil,
 				process:    setVariantsCommand,
 			},
+			variantPrototype{
+				id:         "sc",
+				text:       trans("editing_commands_scale"),
+				isActiveFn: nil,
+				process:    setScaleCommand,
+			},
+			variantPrototype{
+				id:   "rk",
+				text: trans("editing_commands_make_ranked"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+					return data.Static.Db.GetQuestionType(questionId) == database.QuestionTypeChoice
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setQuestionRanked(sendGuide, data, true)
+				},
+			},
+			variantPrototype{
+				id:   "sg",
+				text: trans("editing_commands_make_single_choice"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+					return data.Static.Db.GetQuestionType(questionId) == database.QuestionTypeRanked
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setQuestionRanked(sendGuide, data, false)
+				},
+			},
+			variantPrototype{
+				id:         "tp",
+				text:       trans("editing_commands_template"),
+				isActiveFn: nil,
+				process:    chooseTemplateCommand,
+			},
 			variantPrototype{
 				id:         "sr",
 				text:       trans("editing_commands_rules"),
 				isActiveFn: nil,
 				process:    setRulesCommand,
 			},
+			variantPrototype{
+				id:   "pb",
+				text: trans("editing_commands_make_public"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+					return !data.Static.Db.IsQuestionPublic(questionId)
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setQuestionPublic(sendGuide, data, true)
+				},
+			},
+			variantPrototype{
+				id:   "an",
+				text: trans("editing_commands_make_anonymous"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+					return data.Static.Db.IsQuestionPublic(questionId)
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setQuestionPublic(sendGuide, data, false)
+				},
+			},
+			makeResultsVisibilityVariant(sendGuide, "vc", trans("editing_commands_results_at_close"), database.ResultsVisibilityAtClose),
+			makeResultsVisibilityVariant(sendGuide, "vv", trans("editing_commands_results_after_vote"), database.ResultsVisibilityAfterVote),
+			makeResultsVisibilityVariant(sendGuide, "va", trans("editing_commands_results_author"), database.ResultsVisibilityAuthor),
+			variantPrototype{
+				id:   "ns",
+				text: trans("editing_commands_next_survey_question"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+					return data.Static.Db.IsQuestionHasText(questionId) && data.Static.Db.GetQuestionVariantsCount(questionId) > 0 &&
+						len(processing.GetQuestionContentViolations(data, questionId)) == 0
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					addSurveyQuestionCommand(sendGuide, data)
+				},
+			},
 			variantPrototype{
 				id:   "co",
 				text: trans("editing_commands_commit"),
-				isActiveFn: func(data *processing.ProcessData) bool {
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
 					questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
-					return data.Static.Db.IsQuestionReady(questionId)
+					return data.Static.Db.IsQuestionReady(questionId) && len(processing.GetQuestionContentViolations(data, questionId)) == 0
 				},
 				process: commitQuestionCommand,
 			},
@@ -46,9 +124,10 @@ func MakeQuestionEditDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
 			},
 		},
 	})
+	return factory
 }
 
-func setTextCommand(data *processing.ProcessData) {
+func setTextCommand(additionalId int64, data *processing.ProcessData) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		data.Static.UserStates[data.ChatId] = processing.WaitingText
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_question_text"))
@@ -57,7 +136,7 @@ func setTextCommand(data *processing.ProcessData) {
 	}
 }
 
-func setVariantsCommand(data *processing.ProcessData) {
+func setVariantsCommand(additionalId int64, data *processing.ProcessData) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		data.Static.UserStates[data.ChatId] = processing.WaitingVariants
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_variants"))
@@ -66,7 +145,24 @@ func setVariantsCommand(data *processing.ProcessData) {
 	}
 }
 
-func setRulesCommand(data *processing.ProcessData) {
+func setScaleCommand(additionalId int64, data *processing.ProcessData) {
+	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.UserStates[data.ChatId] = processing.WaitingScale
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_scale"))
+	} else {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+	}
+}
+
+func chooseTemplateCommand(additionalId int64, data *processing.ProcessData) {
+	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, processing.GetTemplatesListText(data.Static))
+	} else {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+	}
+}
+
+func setRulesCommand(additionalId int64, data *processing.ProcessData) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		data.Static.UserStates[data.ChatId] = processing.WaitingRules
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_rules"))
@@ -75,12 +171,12 @@ func setRulesCommand(data *processing.ProcessData) {
 	}
 }
 
-func commitQuestionCommand(data *processing.ProcessData) {
+func commitQuestionCommand(additionalId int64, data *processing.ProcessData) {
 	if data.Static.Db.IsUserBanned(data.UserId) {
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_youre_banned"))
 		if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 			questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
-			data.Static.Db.DiscardQuestion(questionId)
+			processing.DiscardEditedQuestion(data.Static, questionId)
 			processing.ProcessNextQuestion(data)
 		}
 		return
@@ -88,6 +184,15 @@ func commitQuestionCommand(data *processing.ProcessData) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
 		if data.Static.Db.IsQuestionReady(questionId) && data.Static.Db.GetQuestionVariantsCount(questionId) > 0 {
+			if len(processing.GetQuestionContentViolations(data, questionId)) > 0 {
+				data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_violates_policy"))
+				return
+			}
+			if quotaWarning := processing.GetQuestionQuotaWarning(data); quotaWarning != "" {
+				// keep the question as a draft so it can be committed later
+				data.Static.Chat.SendMessage(data.ChatId, quotaWarning)
+				return
+			}
 			processing.CommitQuestion(data, questionId)
 		} else {
 			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_ready"))
@@ -97,10 +202,78 @@ func commitQuestionCommand(data *processing.ProcessData) {
 	}
 }
 
-func discardQuestionCommand(data *processing.ProcessData) {
+func makeResultsVisibilityVariant(sendGuide func(data *processing.ProcessData), id string, text string, visibility int) variantPrototype {
+	return variantPrototype{
+		id:   id,
+		text: text,
+		isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+			questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+			return data.Static.Db.GetQuestionResultsVisibility(questionId) != visibility
+		},
+		process: func(additionalId int64, data *processing.ProcessData) {
+			if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+				data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+				return
+			}
+
+			questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+			data.Static.Db.SetQuestionResultsVisibility(questionId, visibility)
+			sendGuide(data)
+		},
+	}
+}
+
+func setQuestionPublic(sendGuide func(data *processing.ProcessData), data *processing.ProcessData, isPublic bool) {
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+		return
+	}
+
+	questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+	data.Static.Db.SetQuestionPublic(questionId, isPublic)
+	sendGuide(data)
+}
+
+func setQuestionRanked(sendGuide func(data *processing.ProcessData), data *processing.ProcessData, isRanked bool) {
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+		return
+	}
+
+	questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+	if isRanked {
+		data.Static.Db.SetQuestionType(questionId, database.QuestionTypeRanked)
+	} else {
+		data.Static.Db.SetQuestionType(questionId, database.QuestionTypeChoice)
+	}
+	sendGuide(data)
+}
+
+func addSurveyQuestionCommand(sendGuide func(data *processing.ProcessData), data *processing.ProcessData) {
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
+		return
+	}
+
+	questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+	if !data.Static.Db.IsQuestionHasText(questionId) || data.Static.Db.GetQuestionVariantsCount(questionId) == 0 ||
+		len(processing.GetQuestionContentViolations(data, questionId)) > 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_ready"))
+		return
+	}
+
+	if !processing.AddQuestionToSurvey(data, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_survey_too_long"))
+		return
+	}
+
+	sendGuide(data)
+}
+
+func discardQuestionCommand(additionalId int64, data *processing.ProcessData) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
-		data.Static.Db.DiscardQuestion(questionId)
+		processing.DiscardEditedQuestion(data.Static, questionId)
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_discarded"))
 		processing.ProcessNextQuestion(data)
 	} else {
@@ -108,12 +281,23 @@ func discardQuestionCommand(data *processing.ProcessData) {
 	}
 }
 
-func getEditingGuide(data *processing.ProcessData) string {
+func getEditingGuide(additionalId int64, data *processing.ProcessData) string {
 	questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
 
 	var buffer bytes.Buffer
 	buffer.WriteString(data.Static.Trans("question_header"))
 
+	if surveyId, ok := data.Static.Db.GetQuestionSurvey(questionId); ok {
+		questions := data.Static.Db.GetSurveyQuestions(surveyId)
+		buffer.WriteString(data.Static.Trans("survey_caption", map[string]interface{}{
+			"Position": len(questions),
+		}))
+		// the previous questions of the survey can't be edited anymore
+		for i, surveyQuestionId := range questions[:len(questions)-1] {
+			buffer.WriteString(fmt.Sprintf("\n<i>%d</i> - %s", i+1, data.Static.Db.GetQuestionText(surveyQuestionId)))
+		}
+	}
+
 	buffer.WriteString(data.Static.Trans("text_caption"))
 	if data.Static.Db.IsQuestionHasText(questionId) {
 		buffer.WriteString(fmt.Sprintf("%s", data.Static.Db.GetQuestionText(questionId)))
@@ -128,6 +312,12 @@ func getEditingGuide(data *processing.ProcessData) string {
 		for i, variant := range variants {
 			buffer.WriteString(fmt.Sprintf("\n<i>%d</i> - %s", i+1, variant))
 		}
+		switch data.Static.Db.GetQuestionType(questionId) {
+		case database.QuestionTypeScale:
+			buffer.WriteString("\n" + data.Static.Trans("question_type_scale"))
+		case database.QuestionTypeRanked:
+			buffer.WriteString("\n" + data.Static.Trans("question_type_ranked"))
+		}
 	} else {
 		buffer.WriteString(data.Static.Trans("not_set"))
 	}
@@ -140,5 +330,30 @@ func getEditingGuide(data *processing.ProcessData) string {
 		buffer.WriteString(data.Static.Trans("not_set"))
 	}
 
+	buffer.WriteString(data.Static.Trans("voting_caption"))
+	if data.Static.Db.IsQuestionPublic(questionId) {
+		buffer.WriteString(data.Static.Trans("voting_public"))
+	} else {
+		buffer.WriteString(data.Static.Trans("voting_anonymous"))
+	}
+
+	buffer.WriteString(data.Static.Trans("results_visibility_caption"))
+	switch data.Static.Db.GetQuestionResultsVisibility(questionId) {
+	case database.ResultsVisibilityAfterVote:
+		buffer.WriteString(data.Static.Trans("results_visibility_after_vote"))
+	case database.ResultsVisibilityAuthor:
+		buffer.WriteString(data.Static.Trans("results_visibility_author"))
+	default:
+		buffer.WriteString(data.Static.Trans("results_visibility_at_close"))
+	}
+
+	violations := processing.GetQuestionContentViolations(data, questionId)
+	if len(violations) > 0 {
+		buffer.WriteString(data.Static.Trans("violations_caption"))
+		for _, violation := range violations {
+			buffer.WriteString("\n- " + violation)
+		}
+	}
+
 	return buffer.String()
 }
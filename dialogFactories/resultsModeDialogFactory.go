@@ -0,0 +1,61 @@
+package dialogFactories
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/processing"
+	"github.com/nicksnyder/go-i18n/i18n"
+)
+
+func MakeResultsModeDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
+	return &(DialogFactory{
+		getTextFn: getResultsModeText,
+		variants: []variantPrototype{
+			variantPrototype{
+				id:   "all",
+				text: trans("results_mode_commands_all"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					return data.Static.Db.GetUserResultsMode(data.UserId) != database.ResultsModeAll
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setResultsMode(data, database.ResultsModeAll)
+				},
+			},
+			variantPrototype{
+				id:   "ans",
+				text: trans("results_mode_commands_answered"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					return data.Static.Db.GetUserResultsMode(data.UserId) != database.ResultsModeAnswered
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setResultsMode(data, database.ResultsModeAnswered)
+				},
+			},
+			variantPrototype{
+				id:   "no",
+				text: trans("results_mode_commands_none"),
+				isActiveFn: func(additionalId int64, data *processing.ProcessData) bool {
+					return data.Static.Db.GetUserResultsMode(data.UserId) != database.ResultsModeNone
+				},
+				process: func(additionalId int64, data *processing.ProcessData) {
+					setResultsMode(data, database.ResultsModeNone)
+				},
+			},
+		},
+	})
+}
+
+func getResultsModeText(additionalId int64, data *processing.ProcessData) string {
+	switch data.Static.Db.GetUserResultsMode(data.UserId) {
+	case database.ResultsModeAnswered:
+		return data.Static.Trans("results_mode_answered")
+	case database.ResultsModeNone:
+		return data.Static.Trans("results_mode_none")
+	default:
+		return data.Static.Trans("results_mode_all")
+	}
+}
+
+func setResultsMode(data *processing.ProcessData, mode int) {
+	data.Static.Db.SetUserResultsMode(data.UserId, mode)
+	data.Static.Chat.SendMessage(data.ChatId, getResultsModeText(0, data))
+}
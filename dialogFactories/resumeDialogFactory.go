@@ -0,0 +1,53 @@
+package dialogFactories
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/processing"
+	"github.com/nicksnyder/go-i18n/i18n"
+)
+
+func MakeResumeDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
+	return &(DialogFactory{
+		getTextFn: func(additionalId int64, data *processing.ProcessData) string {
+			return data.Static.Trans("ask_catch_up")
+		},
+		variants: []variantPrototype{
+			variantPrototype{
+				id:         "all",
+				text:       trans("resume_commands_catch_up"),
+				isActiveFn: nil,
+				process:    catchUpQuestionsCommand,
+			},
+			variantPrototype{
+				id:         "new",
+				text:       trans("resume_commands_only_new"),
+				isActiveFn: nil,
+				process:    skipMissedQuestionsCommand,
+			},
+		},
+	})
+}
+
+func catchUpQuestionsCommand(additionalId int64, data *processing.ProcessData) {
+	if !data.Static.Db.IsUserSubscribed(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_subscribed"))
+		return
+	}
+
+	hadPendingQuestions := data.Static.Db.IsUserHasPendingQuestions(data.UserId)
+	data.Static.Db.InitNewUserQuestions(data.UserId)
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_missed_questions_added"))
+
+	if !hadPendingQuestions && !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Db.UnmarkUserReady(data.UserId)
+		processing.ProcessNextQuestion(data)
+	}
+}
+
+func skipMissedQuestionsCommand(additionalId int64, data *processing.ProcessData) {
+	if !data.Static.Db.IsUserSubscribed(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_subscribed"))
+		return
+	}
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_only_new_questions"))
+}
@@ -0,0 +1,119 @@
+package dialogFactories
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/processing"
+	"github.com/nicksnyder/go-i18n/i18n"
+)
+
+// MakeReviewDialogFactory creates the dialog for moderators to review a reported question,
+// the additional id is the id of the question
+func MakeReviewDialogFactory(trans i18n.TranslateFunc) *DialogFactory {
+	return &(DialogFactory{
+		getTextFn: getReviewText,
+		variants: []variantPrototype{
+			variantPrototype{
+				id:   "rm",
+				text: trans("review_commands_remove"),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					status := data.Static.Db.GetQuestionStatus(questionId)
+					return status == database.QuestionStatusOpen || status == database.QuestionStatusSuspended
+				},
+				process: removeReportedQuestionCommand,
+			},
+			variantPrototype{
+				id:   "sp",
+				text: trans("review_commands_suspend"),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					return data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusOpen
+				},
+				process: suspendReportedQuestionCommand,
+			},
+			variantPrototype{
+				id:   "ok",
+				text: trans("review_commands_keep"),
+				isActiveFn: func(questionId int64, data *processing.ProcessData) bool {
+					status := data.Static.Db.GetQuestionStatus(questionId)
+					return status == database.QuestionStatusOpen || status == database.QuestionStatusSuspended
+				},
+				process: keepReportedQuestionCommand,
+			},
+		},
+	})
+}
+
+func getReviewText(questionId int64, data *processing.ProcessData) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(data.Static.Trans("review_header", map[string]interface{}{
+		"Id":    questionId,
+		"Count": data.Static.Db.GetQuestionReportsCount(questionId),
+	}))
+	if data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusSuspended {
+		buffer.WriteString("\n" + data.Static.Trans("review_suspended"))
+	}
+
+	buffer.WriteString("\n\n" + data.Static.Db.GetQuestionText(questionId))
+	for i, variant := range data.Static.Db.GetQuestionVariants(questionId) {
+		buffer.WriteString(fmt.Sprintf("\n<i>%d</i> - %s", i+1, variant))
+	}
+
+	return buffer.String()
+}
+
+// isReviewAllowed checks that the user can review the question and it's not closed or removed yet
+func isReviewAllowed(questionId int64, data *processing.ProcessData) bool {
+	if !processing.IsUserModerator(data) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
+		return false
+	}
+
+	status := data.Static.Db.GetQuestionStatus(questionId)
+	if status != database.QuestionStatusOpen && status != database.QuestionStatusSuspended {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_outdated"))
+		return false
+	}
+
+	return true
+}
+
+func removeReportedQuestionCommand(questionId int64, data *processing.ProcessData) {
+	if !isReviewAllowed(questionId, data) {
+		return
+	}
+
+	surveyId, isInSurvey := data.Static.Db.GetQuestionSurvey(questionId)
+	processing.RemoveActiveQuestion(data.Static, questionId)
+	data.Static.Db.RemoveQuestion(questionId)
+	data.Log.WithField("question_id", questionId).Info("Reported question removed")
+	// the rest of the survey was suspended together with the question
+	if isInSurvey {
+		processing.RestoreSurvey(data.Static, surveyId)
+	}
+	processing.LogModeratorAction(data, "rm", fmt.Sprintf("q%d", questionId), "reports")
+	data.Static.Chat.SendMessage(data.ChatId, "removed")
+}
+
+func suspendReportedQuestionCommand(questionId int64, data *processing.ProcessData) {
+	if !isReviewAllowed(questionId, data) {
+		return
+	}
+
+	processing.SuspendQuestion(data.Static, questionId)
+	processing.LogModeratorAction(data, "suspend", fmt.Sprintf("q%d", questionId), "reports")
+	data.Static.Chat.SendMessage(data.ChatId, "suspended")
+}
+
+func keepReportedQuestionCommand(questionId int64, data *processing.ProcessData) {
+	if !isReviewAllowed(questionId, data) {
+		return
+	}
+
+	// the reporters are excluded when the question is restored, so the reports are cleared after it
+	processing.RestoreQuestion(data.Static, questionId)
+	data.Static.Db.ClearQuestionReports(questionId)
+	processing.LogModeratorAction(data, "keep", fmt.Sprintf("q%d", questionId), "reports")
+	data.Static.Chat.SendMessage(data.ChatId, "kept")
+}
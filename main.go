@@ -2,17 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gameraccoon/telegram-poll-bot/database"
 	"github.com/gameraccoon/telegram-poll-bot/dialogFactories"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
 	"github.com/gameraccoon/telegram-poll-bot/processing"
 	"github.com/gameraccoon/telegram-poll-bot/telegramChat"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/nicksnyder/go-i18n/i18n"
+	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"log"
+	"os"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata"
 )
 
 func init() {
@@ -42,7 +47,97 @@ func loadConfig(path string) (config processing.StaticConfiguration, err error)
 	return
 }
 
-func updateTimers(staticData *processing.StaticProccessStructs, mutex *sync.Mutex) {
+// stdLogWriter passes lines that the libraries write with the standard logger to logrus
+type stdLogWriter struct{}
+
+// getStdLogLevel guesses the level of a line by the words it starts with, e.g. "error: ..." or "[WARN] ..."
+func getStdLogLevel(line string) logrus.Level {
+	prefix := strings.ToLower(strings.TrimLeft(line, "[ "))
+	switch {
+	case strings.HasPrefix(prefix, "panic"), strings.HasPrefix(prefix, "fatal"), strings.HasPrefix(prefix, "error"):
+		return logrus.ErrorLevel
+	case strings.HasPrefix(prefix, "warn"):
+		return logrus.WarnLevel
+	case strings.HasPrefix(prefix, "debug"):
+		return logrus.DebugLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
+
+func (writer stdLogWriter) Write(p []byte) (n int, err error) {
+	line := strings.TrimSpace(string(p))
+	logrus.WithField("component", "stdlog").Log(getStdLogLevel(line), line)
+	return len(p), nil
+}
+
+// exitOnPanic logs the panic recovered from the failed operation with its context and exits,
+// the database can't recover from its errors
+func exitOnPanic(log *logrus.Entry, recovered interface{}) {
+	if recovered == nil {
+		return
+	}
+	if entry, ok := recovered.(*logrus.Entry); ok {
+		log.Fatal(entry.Message)
+	}
+	log.Fatal(recovered)
+}
+
+func setupLogging(config *processing.StaticConfiguration) error {
+	if config.LogLevel != "" {
+		level, err := logrus.ParseLevel(config.LogLevel)
+		if err != nil {
+			return err
+		}
+		logrus.SetLevel(level)
+	}
+
+	switch config.LogFormat {
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case "", "logfmt":
+		logrus.SetFormatter(&logrus.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+	default:
+		return fmt.Errorf("Unknown log format: %s", config.LogFormat)
+	}
+
+	if config.LogFile != "" {
+		file, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		logrus.SetOutput(file)
+	}
+
+	// some libraries still use the standard logger
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	tgbotapi.SetLogger(logrus.WithField("component", "telegram"))
+
+	return nil
+}
+
+func parseQuestionsOrder(order string) (int, error) {
+	switch order {
+	case "", "oldest":
+		return database.QuestionsOrderOldest, nil
+	case "deadline":
+		return database.QuestionsOrderDeadline, nil
+	case "fewest_answers":
+		return database.QuestionsOrderFewestAnswers, nil
+	default:
+		return 0, fmt.Errorf("Unknown questions order: %s", order)
+	}
+}
+
+func updateTimers(staticData *processing.StaticProccessStructs, dialogManager *dialogFactories.DialogManager, mutex *sync.Mutex) {
+	defer func() {
+		exitOnPanic(logrus.WithField("component", "timers"), recover())
+	}()
+
 	questions := staticData.Db.GetActiveQuestions()
 
 	mutex.Lock()
@@ -59,37 +154,76 @@ func updateTimers(staticData *processing.StaticProccessStructs, mutex *sync.Mute
 		mutex.Lock()
 		for questionId, endTime := range staticData.Timers {
 			if endTime.Sub(currentTime).Seconds() < 0.0 {
+				metrics.ObserveTimerLag(currentTime.Sub(endTime))
 				delete(staticData.Timers, questionId)
 				processTimer(staticData, questionId)
 			}
 		}
+		processExpiredPauses(staticData, dialogManager)
+		processExpiredBans(staticData)
+		processDigests(staticData)
 		mutex.Unlock()
 		time.Sleep(30 * time.Second)
 	}
 }
 
-func updateBot(bot *tgbotapi.BotAPI, staticData *processing.StaticProccessStructs, dialogManager *dialogFactories.DialogManager, mutex *sync.Mutex) {
+// processBlockedChat stops sending anything to the user that blocked the bot
+func processBlockedChat(staticData *processing.StaticProccessStructs, chatId int64) {
+	logger := logrus.WithField("chat_id", chatId)
+	defer func() {
+		exitOnPanic(logger, recover())
+	}()
+
+	staticData.Db.FailChatOutboxMessages(chatId, "The user blocked the bot")
+	staticData.Db.MarkUserInactive(chatId)
+	logger.Info("User marked inactive")
+}
+
+func updateBot(chat *telegramChat.TelegramChat, staticData *processing.StaticProccessStructs, dialogManager *dialogFactories.DialogManager, mutex *sync.Mutex) {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	updates, err := bot.GetUpdatesChan(u)
+	updates, err := chat.GetBot().GetUpdatesChan(u)
 
 	if err != nil {
-		log.Fatal(err.Error())
+		logrus.Fatal(err.Error())
 	}
 
 	processors := Processors{
 		Main:      makeUserCommandProcessors(),
 		Moderator: makeModeratorCommandProcessors(),
+		Admin:     makeAdminCommandProcessors(),
 	}
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if update.Message == nil {
+				continue
+			}
+			mutex.Lock()
+			processUpdate(&update, staticData, dialogManager, &processors)
+			mutex.Unlock()
+		case chatId := <-chat.GetBlockedChats():
+			mutex.Lock()
+			processBlockedChat(staticData, chatId)
+			mutex.Unlock()
+		}
+	}
+}
+
+// bootstrapAdmins gives the admin role to the users listed in the config,
+// the admin commands refuse to revoke it because it would be granted again on the next start
+func bootstrapAdmins(db *database.Database, config *processing.StaticConfiguration) {
+	for _, chatId := range config.Moderators {
+		userId := db.GetUserId(chatId)
+		if db.GetUserRole(userId) != database.RoleAdmin {
+			db.SetUserRole(userId, database.RoleAdmin)
+			logrus.WithField("chat_id", chatId).Info("Admin role granted from the config")
 		}
-		mutex.Lock()
-		processUpdate(&update, staticData, dialogManager, &processors)
-		mutex.Unlock()
 	}
 }
 
@@ -104,48 +238,76 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	trans, err := i18n.Tfunc(config.Language)
+	err = setupLogging(&config)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	trans, err := i18n.Tfunc(config.Language)
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+
 	db := &database.Database{}
 	err = db.Connect("./polls-data.db")
 	defer db.Disconnect()
 
 	if err != nil {
-		log.Fatal("Can't connect database")
+		logrus.Fatal("Can't connect database")
 	}
 
 	database.UpdateVersion(db)
 
+	questionsOrder, err := parseQuestionsOrder(config.QuestionsOrder)
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+	db.SetQuestionsOrder(questionsOrder)
+
+	bootstrapAdmins(db, &config)
+
 	userStates := make(map[int64]processing.UserState)
 
 	timers := make(map[int64]time.Time)
 
 	mutex := &sync.Mutex{}
 
-	chat, err := telegramChat.MakeTelegramChat(apiToken)
+	chat, err := telegramChat.MakeTelegramChat(apiToken, db, trans)
 	if err != nil {
-		log.Fatal(err.Error())
+		logrus.Fatal(err.Error())
 	}
 
-	log.Printf("Authorized on account %s", chat.GetBotUsername())
+	logrus.Infof("Authorized on account %s", chat.GetBotUsername())
 
-	chat.SetDebugModeEnabled(config.ExtendedLog)
+	// the debug output of the Telegram library contains the raw updates with the texts of the messages
+	if config.ExtendedLog && config.PrivacyMode {
+		logrus.Warning("Extended log is disabled in privacy mode")
+	}
+	chat.SetDebugModeEnabled(config.ExtendedLog && !config.PrivacyMode)
+
+	if config.MetricsAddress != "" {
+		metrics.StartServer(config.MetricsAddress)
+		logrus.Infof("Metrics are served on %s/metrics", config.MetricsAddress)
+	}
 
 	dialogManager := &(dialogFactories.DialogManager{})
 	dialogManager.RegisterDialogFactory("ed", dialogFactories.MakeQuestionEditDialogFactory(trans))
+	dialogManager.RegisterDialogFactory("rs", dialogFactories.MakeResumeDialogFactory(trans))
+	dialogManager.RegisterDialogFactory("rn", dialogFactories.MakeResultsModeDialogFactory(trans))
+	dialogManager.RegisterDialogFactory("rv", dialogFactories.MakeReviewDialogFactory(trans))
+	dialogManager.RegisterDialogFactory("aq", dialogFactories.MakeAuthorQuestionDialogFactory(trans))
 
 	staticData := &processing.StaticProccessStructs{
-		Chat:       chat,
-		Db:         db,
-		Config:     &config,
-		Timers:     timers,
-		Trans:      trans,
-		UserStates: userStates,
+		Chat:            chat,
+		Db:              db,
+		Config:          &config,
+		Timers:          timers,
+		Trans:           trans,
+		UserStates:      userStates,
+		ContentPolicy:   processing.MakeConfigContentPolicy(&config),
+		EditedQuestions: make(map[int64]int64),
 	}
 
-	go updateTimers(staticData, mutex)
-	updateBot(chat.GetBot(), staticData, dialogManager, mutex)
+	go updateTimers(staticData, dialogManager, mutex)
+	updateBot(chat, staticData, dialogManager, mutex)
 }
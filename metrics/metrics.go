@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+var (
+	processedUpdates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pollbot_processed_updates_total",
+			Help: "Number of processed updates by command",
+		},
+		[]string{"command"},
+	)
+
+	recordedAnswers = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pollbot_recorded_answers_total",
+			Help: "Number of answers recorded",
+		},
+	)
+
+	committedQuestions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pollbot_committed_questions_total",
+			Help: "Number of questions committed by authors",
+		},
+	)
+
+	completedQuestions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pollbot_completed_questions_total",
+			Help: "Number of questions completed with results",
+		},
+	)
+
+	sendFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pollbot_telegram_send_failures_total",
+			Help: "Number of messages that Telegram failed to deliver",
+		},
+	)
+
+	timerLag = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pollbot_timer_lag_seconds",
+			Help:    "Delay between question end time and its processing",
+			Buckets: []float64{1, 5, 10, 20, 30, 45, 60, 120, 300},
+		},
+	)
+
+	queryLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "pollbot_db_query_duration_seconds",
+			Help:    "Latency of SQLite queries including fetching of their rows",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		processedUpdates,
+		recordedAnswers,
+		committedQuestions,
+		completedQuestions,
+		sendFailures,
+		timerLag,
+		queryLatency,
+	)
+}
+
+// StartServer serves the /metrics endpoint on the given address in background
+func StartServer(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			logrus.Errorf("Metrics server stopped: %s", err.Error())
+		}
+	}()
+}
+
+func CountProcessedUpdate(command string) {
+	processedUpdates.WithLabelValues(command).Inc()
+}
+
+func CountRecordedAnswer() {
+	recordedAnswers.Inc()
+}
+
+func CountCommittedQuestion() {
+	committedQuestions.Inc()
+}
+
+func CountCompletedQuestion() {
+	completedQuestions.Inc()
+}
+
+func CountSendFailure() {
+	sendFailures.Inc()
+}
+
+func ObserveTimerLag(lag time.Duration) {
+	timerLag.Observe(lag.Seconds())
+}
+
+func ObserveQueryLatency(startTime time.Time) {
+	queryLatency.Observe(time.Since(startTime).Seconds())
+}
@@ -2,18 +2,34 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"fmt"
 	"github.com/gameraccoon/telegram-poll-bot/database"
 	"github.com/gameraccoon/telegram-poll-bot/dialogFactories"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
 	"github.com/gameraccoon/telegram-poll-bot/processing"
 	//"github.com/gameraccoon/telegram-poll-bot/telegramChat"
 	//"github.com/gameraccoon/telegram-poll-bot/dialog"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/sirupsen/logrus"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	maxDigestQuestions = 10
+	maxDigestResults   = 5
+
+	maxPendingQuestionsListed = 20
+	moderationLogPageSize     = 20
+	// the biggest count of the numbers in a scale question
+	maxScaleVariants = 21
+	// time format for the records that are written in UTC
+	logTimeFormat = "2006-01-02 15:04 UTC"
+)
+
 type ProcessorFunc func(*processing.ProcessData, *dialogFactories.DialogManager)
 
 type ProcessorFuncMap map[string]ProcessorFunc
@@ -21,260 +37,1302 @@ type ProcessorFuncMap map[string]ProcessorFunc
 type Processors struct {
 	Main      ProcessorFuncMap
 	Moderator ProcessorFuncMap
+	Admin     ProcessorFuncMap
 }
 
-func sendResults(staticData *processing.StaticProccessStructs, questionId int64, chatIds []int64) {
-	variants := staticData.Db.GetQuestionVariants(questionId)
-	answers := staticData.Db.GetQuestionAnswers(questionId)
-	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+func sendAnswerFeedback(data *processing.ProcessData, questionId int64) {
+	if processing.IsQuestionReadyToBeCompleted(data.Static, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_answer_added"))
+		return
+	}
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_answer_added")+"\n"+processing.GetDificientDataForQuestionText(data.Static, questionId))
+}
+
+func parseAnswer(data *processing.ProcessData) {
+	questionId := data.Static.Db.GetUserNextQuestion(data.UserId)
+	variantsCount := data.Static.Db.GetQuestionVariantsCount(questionId)
+
+	if data.Command == "skip" {
+		data.Static.Db.ClearUserBallot(questionId, data.UserId)
+		data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_skipped"))
+
+		processing.ProcessCompleteness(data.Static, questionId)
+
+		processing.ProcessNextQuestion(data)
+		return
+	}
+
+	if !strings.HasPrefix(data.Command, "ans") {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+		return
+	}
+
+	answer, err := strconv.ParseInt(data.Command[3:len(data.Command)], 10, 64)
+	if err != nil {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+		return
+	}
+
+	answer -= 1
+
+	if answer >= 0 && int(answer) < variantsCount {
+		if data.Static.Db.GetQuestionType(questionId) == database.QuestionTypeRanked {
+			if addRankedChoice(data, questionId, answer) {
+				processing.ProcessNextQuestion(data)
+			}
+			return
+		}
+		recordAnswer(data, questionId, answer)
+		processing.ProcessNextQuestion(data)
+	} else {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+	}
+}
+
+func recordAnswer(data *processing.ProcessData, questionId int64, answer int64) {
+	data.Static.Db.AddQuestionAnswer(questionId, data.UserId, answer)
+	metrics.CountRecordedAnswer()
+	data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
+
+	sendAnswerFeedback(data, questionId)
+
+	isCompleted := processing.ProcessCompleteness(data.Static, questionId)
+
+	// the results of completed questions are sent to the users anyway
+	if !isCompleted && data.Static.Db.GetQuestionResultsVisibility(questionId) == database.ResultsVisibilityAfterVote {
+		data.Static.Chat.SendMessage(data.ChatId, processing.GetCurrentResultsText(data.Static, questionId))
+	}
+}
+
+// addRankedChoice adds the next preferred variant to the ballot of the user,
+// returns true when the ballot is complete and the answer is recorded
+func addRankedChoice(data *processing.ProcessData, questionId int64, answer int64) (isRecorded bool) {
+	ballot := data.Static.Db.GetUserBallot(questionId, data.UserId)
+	for _, variant := range ballot {
+		if int64(variant) == answer {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_variant_already_ranked"))
+			return false
+		}
+	}
+
+	data.Static.Db.AddBallotRank(questionId, data.UserId, answer)
+	ballot = append(ballot, int(answer))
+
+	variantsCount := data.Static.Db.GetQuestionVariantsCount(questionId)
+	if len(ballot) < variantsCount-1 {
+		sendRankingProgress(data, questionId, ballot)
+		return false
+	}
+
+	// the last variant can take only the last place
+	if len(ballot) == variantsCount-1 {
+		isRanked := make(map[int]bool)
+		for _, variant := range ballot {
+			isRanked[variant] = true
+		}
+		for variant := 0; variant < variantsCount; variant++ {
+			if !isRanked[variant] {
+				data.Static.Db.AddBallotRank(questionId, data.UserId, int64(variant))
+			}
+		}
+	}
+
+	recordAnswer(data, questionId, int64(ballot[0]))
+	return true
+}
+
+// sendRankingProgress shows the variants the user ranked so far and the commands to choose the next one
+func sendRankingProgress(data *processing.ProcessData, questionId int64, ballot []int) {
+	variants := data.Static.Db.GetQuestionVariants(questionId)
 
 	var buffer bytes.Buffer
-	buffer.WriteString(staticData.Trans("results_header"))
-	buffer.WriteString(fmt.Sprintf("<i>%s</i>", staticData.Db.GetQuestionText(questionId)))
+	buffer.WriteString(data.Static.Trans("ranked_progress"))
+	isRanked := make(map[int]bool)
+	for place, variant := range ballot {
+		isRanked[variant] = true
+		if variant < len(variants) {
+			buffer.WriteString(fmt.Sprintf("\n%d. %s", place+1, variants[variant]))
+		}
+	}
 
+	buffer.WriteString("\n" + data.Static.Trans("ranked_choose_next"))
 	for i, variant := range variants {
-		buffer.WriteString(fmt.Sprintf("\n%s - %d (%d%%)", variant, answers[i], int64(100.0*float32(answers[i])/float32(answersCount))))
+		if !isRanked[i] {
+			buffer.WriteString(fmt.Sprintf("\n/a%d_%d - %s", questionId, i+1, variant))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("\n/done%d - %s", questionId, data.Static.Trans("ranked_finish_command")))
+
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
+
+// processFinishRanking processes commands like "done12" that record a ballot without ranking all the variants
+func processFinishRanking(data *processing.ProcessData) bool {
+	if !strings.HasPrefix(data.Command, "done") {
+		return false
+	}
+
+	questionId, err := strconv.ParseInt(data.Command[len("done"):], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if !data.Static.Db.IsQuestionPendingForUser(data.UserId, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_pending"))
+		return true
+	}
+
+	ballot := data.Static.Db.GetUserBallot(questionId, data.UserId)
+	if len(ballot) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_ranking_empty"))
+		return true
+	}
+
+	isCurrentQuestion := (data.Static.Db.GetUserNextQuestion(data.UserId) == questionId)
+
+	recordAnswer(data, questionId, int64(ballot[0]))
+
+	if isCurrentQuestion && !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		processing.ProcessNextQuestion(data)
+	}
+	return true
+}
+
+// canUserSeeResults checks whether the user can see the results of the question now
+func canUserSeeResults(staticData *processing.StaticProccessStructs, userId int64, questionId int64) bool {
+	switch staticData.Db.GetQuestionStatus(questionId) {
+	case database.QuestionStatusClosed:
+		return true
+	case database.QuestionStatusOpen:
+		author, err := staticData.Db.GetAuthor(questionId)
+		isAuthor := err == nil && author == userId
+
+		switch staticData.Db.GetQuestionResultsVisibility(questionId) {
+		case database.ResultsVisibilityAfterVote:
+			return isAuthor || staticData.Db.IsQuestionAnsweredByUser(userId, questionId)
+		case database.ResultsVisibilityAuthor:
+			return isAuthor
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+func resultsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questionId, err := strconv.ParseInt(strings.TrimSpace(data.Message), 10, 64)
+	if err != nil {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_results_id"))
+		return
+	}
+
+	if !canUserSeeResults(data.Static, data.UserId, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_results_not_available"))
+		return
+	}
+
+	if data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusClosed {
+		processing.SendResults(data.Static, questionId, data.ChatId)
+	} else {
+		data.Static.Chat.SendMessage(data.ChatId, processing.GetCurrentResultsText(data.Static, questionId))
+	}
+}
+
+// parseDirectAnswerCommand parses commands like "a12_3" that answer a specific question
+func parseDirectAnswerCommand(command string) (questionId int64, answer int64, ok bool) {
+	if !strings.HasPrefix(command, "a") {
+		return
+	}
+
+	ids := strings.Split(command[1:], "_")
+	if len(ids) != 2 {
+		return
+	}
+
+	questionId, err := strconv.ParseInt(ids[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	answer, err = strconv.ParseInt(ids[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	return questionId, answer - 1, true
+}
+
+// processDirectAnswer processes answers to questions from the digest
+func processDirectAnswer(data *processing.ProcessData) bool {
+	questionId, answer, ok := parseDirectAnswerCommand(data.Command)
+	if !ok {
+		return false
+	}
+
+	if !data.Static.Db.IsQuestionPendingForUser(data.UserId, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_pending"))
+		return true
+	}
+
+	if answer < 0 || int(answer) >= data.Static.Db.GetQuestionVariantsCount(questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+		return true
+	}
+
+	isCurrentQuestion := (data.Static.Db.GetUserNextQuestion(data.UserId) == questionId)
+
+	if data.Static.Db.GetQuestionType(questionId) == database.QuestionTypeRanked {
+		if !addRankedChoice(data, questionId, answer) {
+			return true
+		}
+	} else {
+		recordAnswer(data, questionId, answer)
+	}
+
+	if isCurrentQuestion && !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		processing.ProcessNextQuestion(data)
+	}
+	return true
+}
+
+func sendEditingGuide(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	dialog := dialogManager.MakeDialog("ed", 0, data)
+	if dialog != nil {
+		data.Static.Chat.SendDialog(dialog, data.ChatId)
+	}
+}
+
+func addQuestionCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	if data.Static.Db.IsUserBanned(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_youre_banned"))
+		return
+	}
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		if quotaWarning := processing.GetQuestionQuotaWarning(data); quotaWarning != "" {
+			data.Static.Chat.SendMessage(data.ChatId, quotaWarning)
+			return
+		}
+		data.Static.Db.StartCreatingQuestion(data.UserId)
+		data.Static.Db.UnmarkUserReady(data.UserId)
+		data.Static.UserStates[data.ChatId] = processing.WaitingText
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_question_text"))
+	} else {
+		sendEditingGuide(data, dialogManager)
+	}
+}
+
+func startCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	if !data.Static.Db.IsUserActive(data.UserId) {
+		data.Log.Info("User is back")
+		data.Static.Db.ActivateUser(data.UserId)
+	}
+
+	if !data.Static.Db.IsUserSubscribed(data.UserId) {
+		resumeSubscription(data, dialogManager)
+		return
+	}
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("hello_message"))
+	if !data.Static.Db.IsUserHasPendingQuestions(data.UserId) {
+		data.Static.Db.InitNewUserQuestions(data.UserId)
+		data.Static.Db.UnmarkUserReady(data.UserId)
+		processing.ProcessNextQuestion(data)
 	}
-	resultText := buffer.String()
+}
+
+func resumeSubscription(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	data.Static.Db.ResumeUserSubscription(data.UserId)
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Db.MarkUserReady(data.UserId)
+	}
+	data.Log.Info("Subscription resumed")
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_subscription_resumed"))
+	dialog := dialogManager.MakeDialog("rs", 0, data)
+	if dialog != nil {
+		data.Static.Chat.SendDialog(dialog, data.ChatId)
+	}
+}
+
+func stopCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	data.Static.Db.StopUserSubscription(data.UserId)
+	data.Log.Info("Subscription stopped")
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_subscription_stopped"))
+}
 
-	for _, chatId := range chatIds {
-		staticData.Chat.SendMessage(chatId, resultText)
+func pauseCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	duration, ok := processing.ParseDuration(data.Message)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_pause_duration"))
+		return
 	}
+
+	pausedUntil := time.Now().Add(duration).Unix()
+	data.Static.Db.PauseUserSubscription(data.UserId, pausedUntil)
+	data.Log.WithField("paused_until", pausedUntil).Info("Subscription paused")
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_subscription_paused", map[string]interface{}{
+		"Time": processing.FormatTime(pausedUntil, data.Static.Db.GetUserTimezone(data.UserId)),
+	}))
 }
 
-func removeActiveQuestion(staticData *processing.StaticProccessStructs, questionId int64) {
-	staticData.Db.FinishQuestion(questionId)
+func timezoneCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	timezone := strings.TrimSpace(data.Message)
+	if timezone == "" {
+		currentTimezone := data.Static.Db.GetUserTimezone(data.UserId)
+		if currentTimezone == "" {
+			currentTimezone = "UTC"
+		}
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_timezone", map[string]interface{}{
+			"Timezone": currentTimezone,
+		}))
+		return
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_timezone"))
+		return
+	}
+
+	data.Static.Db.SetUserTimezone(data.UserId, timezone)
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_timezone_is_set"))
+}
+
+func quietHoursCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	params := strings.Fields(data.Message)
+
+	if len(params) == 0 {
+		start, end, isSet := data.Static.Db.GetUserQuietHours(data.UserId)
+		if isSet {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_quiet_hours", map[string]interface{}{
+				"Start": processing.FormatDayTime(start),
+				"End":   processing.FormatDayTime(end),
+			}))
+		} else {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_no_quiet_hours"))
+		}
+		return
+	}
+
+	if len(params) == 1 && params[0] == "off" {
+		data.Static.Db.RemoveUserQuietHours(data.UserId)
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_quiet_hours_removed"))
+		return
+	}
+
+	if len(params) != 2 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_quiet_hours"))
+		return
+	}
+
+	start, isStartOk := processing.ParseDayTime(params[0])
+	end, isEndOk := processing.ParseDayTime(params[1])
+	if !isStartOk || !isEndOk || start == end {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_quiet_hours"))
+		return
+	}
+
+	data.Static.Db.SetUserQuietHours(data.UserId, start, end)
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_quiet_hours_is_set"))
+}
 
-	delete(staticData.Timers, questionId)
+func digestCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	param := strings.TrimSpace(data.Message)
 
-	users := staticData.Db.GetUsersAnsweringQuestionNow(questionId)
-	for _, user := range users {
-		staticData.Db.RemoveUserPendingQuestion(user, questionId)
-		chatId := staticData.Db.GetUserChatId(user)
-		staticData.Chat.SendMessage(staticData.Db.GetUserChatId(user), staticData.Trans("say_question_outdated"))
+	if param == "" {
+		digestTime, isEnabled := data.Static.Db.GetUserDigestTime(data.UserId)
+		if isEnabled {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_digest_enabled", map[string]interface{}{
+				"Time": processing.FormatDayTime(digestTime),
+			}))
+		} else {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_digest_disabled"))
+		}
+		return
+	}
 
-		if staticData.Db.IsUserHasPendingQuestions(user) {
-			staticData.Chat.SendQuestion(staticData.Db, staticData.Db.GetUserNextQuestion(user), []int64{chatId})
+	if param == "off" {
+		if data.Static.Db.IsUserInDigestMode(data.UserId) {
+			data.Static.Db.DisableUserDigest(data.UserId)
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_digest_turned_off"))
+			if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+				processing.ProcessNextQuestion(data)
+			}
 		} else {
-			staticData.Db.MarkUserReady(user)
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_digest_disabled"))
 		}
+		return
 	}
 
-	staticData.Db.RemoveQuestionFromAllUsers(questionId)
+	digestTime, ok := processing.ParseDayTime(param)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_digest_time"))
+		return
+	}
+
+	now := time.Now()
+	nextDigestTime := processing.GetNextDayTime(digestTime, data.Static.Db.GetUserTimezone(data.UserId), now)
+	lastDigestTime := now.Unix()
+	if data.Static.Db.IsUserInDigestMode(data.UserId) {
+		// don't lose the results that were finished after the previous digest
+		lastDigestTime = data.Static.Db.GetUserLastDigestTime(data.UserId)
+	}
+	data.Static.Db.EnableUserDigest(data.UserId, digestTime, nextDigestTime.Unix(), lastDigestTime)
+	data.Log.WithField("digest_time", digestTime).Info("Digest mode enabled")
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_digest_enabled", map[string]interface{}{
+		"Time": processing.FormatDayTime(digestTime),
+	}))
 }
 
-func completeQuestion(staticData *processing.StaticProccessStructs, questionId int64) {
-	removeActiveQuestion(staticData, questionId)
-	chatIds := staticData.Db.GetAllUsersChatIds()
-	sendResults(staticData, questionId, chatIds)
+func getDigestQuestionText(staticData *processing.StaticProccessStructs, questionId int64) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("\n\n<b>%s</b>", staticData.Db.GetQuestionText(questionId)))
+	if staticData.Db.IsQuestionPublic(questionId) {
+		buffer.WriteString("\n" + staticData.Trans("warn_public_question"))
+	}
+	if staticData.Db.GetQuestionType(questionId) == database.QuestionTypeRanked {
+		buffer.WriteString("\n" + staticData.Trans("ranked_question_hint"))
+	}
+
+	variants := staticData.Db.GetQuestionVariants(questionId)
+	for i, variant := range variants {
+		buffer.WriteString(fmt.Sprintf("\n/a%d_%d - %s", questionId, i+1, variant))
+	}
+
+	buffer.WriteString("\n")
+	buffer.WriteString(processing.GetDificientDataForQuestionText(staticData, questionId))
+	return buffer.String()
 }
 
-func isQuestionReadyToBeCompleted(staticData *processing.StaticProccessStructs, questionId int64) bool {
-	minAnswers, maxAnswers, _ := staticData.Db.GetQuestionRules(questionId)
+// getDigestText returns the text of the digest or empty string if there is nothing new for the user
+func getDigestText(staticData *processing.StaticProccessStructs, userId int64) string {
+	lastDigestTime := staticData.Db.GetUserLastDigestTime(userId)
+	questions := staticData.Db.GetUserPendingQuestions(userId)
+	finishedQuestions := staticData.Db.GetUserFinishedQuestionsSince(userId, lastDigestTime, maxDigestResults)
 
-	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+	if len(questions) == 0 && len(finishedQuestions) == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(staticData.Trans("digest_header"))
+
+	if len(questions) > 0 {
+		buffer.WriteString(staticData.Trans("digest_questions_caption"))
+		for i, questionId := range questions {
+			if i >= maxDigestQuestions {
+				buffer.WriteString("\n")
+				buffer.WriteString(staticData.Trans("digest_more_questions", len(questions)-maxDigestQuestions))
+				break
+			}
+			buffer.WriteString(getDigestQuestionText(staticData, questionId))
+		}
+	}
+
+	for _, questionId := range finishedQuestions {
+		buffer.WriteString("\n\n")
+		buffer.WriteString(processing.GetResultsText(staticData, questionId))
+	}
 
-	if answersCount >= maxAnswers && maxAnswers > 0 {
+	return buffer.String()
+}
+
+func processDigests(staticData *processing.StaticProccessStructs) {
+	now := time.Now()
+	users := staticData.Db.GetUsersWithDueDigest(now.Unix())
+	for _, userId := range users {
+		digestTime, _ := staticData.Db.GetUserDigestTime(userId)
+		nextDigestTime := processing.GetNextDayTime(digestTime, staticData.Db.GetUserTimezone(userId), now)
+
+		digestText := getDigestText(staticData, userId)
+		if digestText != "" {
+			chatId := staticData.Db.GetUserChatId(userId)
+			staticData.Chat.BroadcastMessage([]int64{chatId}, digestText)
+		}
+
+		staticData.Db.SetUserDigestSent(userId, now.Unix(), nextDigestTime.Unix())
+	}
+}
+
+func pendingCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questions := data.Static.Db.GetUserPendingQuestions(data.UserId)
+	if len(questions) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_no_pending_questions"))
+		return
+	}
+
+	timezone := data.Static.Db.GetUserTimezone(data.UserId)
+	now := time.Now().Unix()
+
+	var buffer bytes.Buffer
+	buffer.WriteString(data.Static.Trans("pending_questions_header"))
+	for i, questionId := range questions {
+		if i >= maxPendingQuestionsListed {
+			buffer.WriteString("\n\n")
+			buffer.WriteString(data.Static.Trans("digest_more_questions", len(questions)-maxPendingQuestionsListed))
+			break
+		}
+
+		buffer.WriteString(fmt.Sprintf("\n\n/q%d <i>%s</i>\n", questionId, data.Static.Db.GetQuestionText(questionId)))
+		buffer.WriteString(data.Static.Trans("answers", data.Static.Db.GetQuestionAnswersCount(questionId)))
+
+		_, _, endTime := data.Static.Db.GetQuestionRules(questionId)
+		if endTime > now {
+			buffer.WriteString(", ")
+			buffer.WriteString(data.Static.Trans("deadline", map[string]interface{}{
+				"Time": processing.FormatTime(endTime, timezone),
+			}))
+		}
+		buffer.WriteString("\n")
+		buffer.WriteString(processing.GetDificientDataForQuestionText(data.Static, questionId))
+	}
+
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
+
+// processJumpToQuestion processes commands like "q12" that make the pending question the current one
+func processJumpToQuestion(data *processing.ProcessData) bool {
+	if !strings.HasPrefix(data.Command, "q") {
+		return false
+	}
+
+	questionId, err := strconv.ParseInt(data.Command[1:], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if !data.Static.Db.IsQuestionPendingForUser(data.UserId, questionId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_pending"))
+		return true
+	}
+
+	if data.Static.Db.IsUserInDigestMode(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, getDigestQuestionText(data.Static, questionId))
+	} else {
+		data.Static.Chat.SendQuestion(data.Static.Db, questionId, []int64{data.ChatId})
+	}
+	return true
+}
+
+// processReportQuestion processes commands like "report12" that report a specific question
+func processReportQuestion(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) bool {
+	if !strings.HasPrefix(data.Command, "report") {
+		return false
+	}
+
+	questionId, err := strconv.ParseInt(data.Command[len("report"):], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	reportQuestion(data, dialogManager, questionId)
+	return true
+}
+
+// processReuseQuestion processes commands like "reuse12" that create a draft from a finished question
+func processReuseQuestion(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) bool {
+	if !strings.HasPrefix(data.Command, "reuse") {
+		return false
+	}
+
+	questionId, err := strconv.ParseInt(data.Command[len("reuse"):], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	reuseQuestion(data, dialogManager, questionId)
+	return true
+}
+
+// reuseQuestion starts a new question with the text, variants and rules of a finished one
+func reuseQuestion(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager, questionId int64) {
+	if data.Static.Db.IsUserBanned(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_youre_banned"))
+		return
+	}
+
+	// only the published results can be reused, drafts and hidden questions are not shown to other users
+	if data.Static.Db.GetQuestionStatus(questionId) != database.QuestionStatusClosed {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_reusable"))
+		return
+	}
+
+	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_already_editing_question"))
+		sendEditingGuide(data, dialogManager)
+		return
+	}
+
+	if quotaWarning := processing.GetQuestionQuotaWarning(data); quotaWarning != "" {
+		data.Static.Chat.SendMessage(data.ChatId, quotaWarning)
+		return
+	}
+
+	data.Static.Db.StartCreatingQuestion(data.UserId)
+	data.Static.Db.UnmarkUserReady(data.UserId)
+	newQuestionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+
+	data.Static.Db.SetQuestionText(newQuestionId, data.Static.Db.GetQuestionText(questionId))
+	values := data.Static.Db.GetQuestionVariantValues(questionId)
+	if data.Static.Db.GetQuestionType(questionId) == database.QuestionTypeScale && len(values) > 0 {
+		data.Static.Db.SetQuestionScale(newQuestionId, values[0], values[len(values)-1])
+	} else {
+		data.Static.Db.SetQuestionVariants(newQuestionId, data.Static.Db.GetQuestionVariants(questionId))
+		data.Static.Db.SetQuestionType(newQuestionId, data.Static.Db.GetQuestionType(questionId))
+	}
+	// the rules of the old questions without the commit time should be set again
+	if duration, ok := data.Static.Db.GetQuestionDuration(questionId); ok {
+		minAnswers, maxAnswers, _ := data.Static.Db.GetQuestionRules(questionId)
+		data.Static.Db.SetQuestionRules(newQuestionId, minAnswers, maxAnswers, duration)
+	}
+
+	data.Log.WithFields(logrus.Fields{
+		"question_id":        newQuestionId,
+		"reused_question_id": questionId,
+	}).Info("Question reused")
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_reused"))
+	sendEditingGuide(data, dialogManager)
+}
+
+// processApplyTemplate processes commands like "tplb1" or "tpl12" that fill the edited question from a template
+func processApplyTemplate(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) bool {
+	if !strings.HasPrefix(data.Command, "tpl") {
+		return false
+	}
+
+	template, ok := processing.FindTemplate(data.Static, data.Command[len("tpl"):])
+	if !ok {
+		return false
+	}
+
+	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_not_editing_question"))
 		return true
 	}
 
-	if _, ok := staticData.Timers[questionId]; !ok {
-		if answersCount >= minAnswers {
+	questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+	processing.ApplyTemplate(data.Static, questionId, template)
+	data.Log.WithFields(logrus.Fields{
+		"question_id": questionId,
+		"template":    data.Command,
+	}).Info("Template applied")
+
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_template_applied"))
+	sendEditingGuide(data, dialogManager)
+	return true
+}
+
+// sendReusableResults sends the results of a finished question with the command to reuse it
+func sendReusableResults(data *processing.ProcessData, questionId int64) {
+	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("%s\n/reuse%d - %s",
+		processing.GetResultsText(data.Static, questionId),
+		questionId,
+		data.Static.Trans("reuse_question_command"),
+	))
+}
+
+// reportCommand reports the question that the user is answering now
+func reportCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	if !data.Static.Db.IsUserHasPendingQuestions(data.UserId) {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_pending"))
+		return
+	}
+
+	reportQuestion(data, dialogManager, data.Static.Db.GetUserNextQuestion(data.UserId))
+}
+
+func reportQuestion(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager, questionId int64) {
+	if data.Static.Db.GetQuestionStatus(questionId) != database.QuestionStatusOpen {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_outdated"))
+		return
+	}
+
+	if !processing.CanUserReportQuestion(data.Static, data.UserId, questionId) {
+		data.Log.WithField("question_id", questionId).Info("Question report rejected")
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_not_reportable"))
+		return
+	}
+
+	data.Static.Db.AddQuestionReport(questionId, data.UserId)
+	reportsCount := data.Static.Db.GetQuestionReportsCount(questionId)
+	data.Log.WithFields(logrus.Fields{
+		"question_id":   questionId,
+		"reports_count": reportsCount,
+	}).Info("Question reported")
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_reported"))
+
+	// the reporter doesn't need to answer the question anymore
+	if data.Static.Db.IsQuestionPendingForUser(data.UserId, questionId) {
+		isCurrentQuestion := data.Static.Db.GetUserNextQuestion(data.UserId) == questionId
+		data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
+		if isCurrentQuestion {
+			processing.ProcessNextQuestion(data)
+		}
+	}
+
+	threshold := data.Static.Config.ReportsToSuspend
+	isSuspended := threshold > 0 && reportsCount >= threshold
+	if isSuspended {
+		processing.SuspendQuestion(data.Static, questionId)
+	}
+
+	// moderators are notified about the first report and when the question is suspended
+	if reportsCount == 1 || isSuspended {
+		dialog := dialogManager.MakeDialog("rv", questionId, data)
+		if dialog != nil {
+			for _, chatId := range data.Static.Db.GetModeratorsChatIds() {
+				data.Static.Chat.SendDialog(dialog, chatId)
+			}
+		}
+	}
+}
+
+func resultNotificationsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	dialog := dialogManager.MakeDialog("rn", 0, data)
+	if dialog != nil {
+		data.Static.Chat.SendDialog(dialog, data.ChatId)
+	}
+}
+
+func lastResultsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questions := data.Static.Db.GetLastFinishedQuestions(10)
+	for _, questionId := range questions {
+		sendReusableResults(data, questionId)
+	}
+}
+
+func myQuestionsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questionsIds := data.Static.Db.GetUserLastQuestions(data.UserId, 10)
+	finishedQuestionsIds := data.Static.Db.GetUserLastFinishedQuestions(data.UserId, 10)
+
+	finishedQuestionsMap := make(map[int64]bool)
+	for _, questionId := range finishedQuestionsIds {
+		finishedQuestionsMap[questionId] = true
+	}
+
+	for _, questionId := range questionsIds {
+		// the authors can reopen their finished questions from the same dialog
+		if _, ok := finishedQuestionsMap[questionId]; ok || data.Static.Db.GetQuestionStatus(questionId) == database.QuestionStatusOpen {
+			dialog := dialogManager.MakeDialog("aq", questionId, data)
+			if dialog != nil {
+				data.Static.Chat.SendDialog(dialog, data.ChatId)
+			}
+		} else {
+			data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("<i>%s</i>\n%s",
+				data.Static.Db.GetQuestionText(questionId),
+				processing.GetDificientDataForQuestionText(data.Static, questionId),
+			))
+		}
+	}
+}
+
+func moderatorListCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questions := data.Static.Db.GetLastPublishedQuestions(15)
+	var buffer bytes.Buffer
+	for _, question := range questions {
+		// the authors are shown so moderators can refer to them in the ban commands
+		authorText := "unknown author"
+		if author, err := data.Static.Db.GetAuthor(question); err == nil {
+			authorText = fmt.Sprintf("u%d", author)
+		}
+		buffer.WriteString(fmt.Sprintf("%d (%s) - %s\n", question, authorText, data.Static.Db.GetQuestionText(question)))
+	}
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
+
+// parseTargetUser finds the user a moderator command is applied to: the author of the forwarded
+// message the moderator replied to, "u<userId>" with the id shown in /m_list and /m_bans,
+// "c<chatId>" with the Telegram chat id or the author of the question with the given id
+func parseTargetUser(data *processing.ProcessData) (userId int64, arguments string, ok bool) {
+	if data.RepliedForwardChatId != 0 {
+		userId, ok = data.Static.Db.FindUserByChatId(data.RepliedForwardChatId)
+		return userId, strings.TrimSpace(data.Message), ok
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(data.Message), " ", 2)
+	if len(fields) > 1 {
+		arguments = strings.TrimSpace(fields[1])
+	}
+
+	if strings.HasPrefix(fields[0], "u") {
+		userId, err := strconv.ParseInt(fields[0][1:], 10, 64)
+		if err != nil || !data.Static.Db.IsUserExists(userId) {
+			return 0, "", false
+		}
+		return userId, arguments, true
+	}
+
+	if strings.HasPrefix(fields[0], "c") {
+		chatId, err := strconv.ParseInt(fields[0][1:], 10, 64)
+		if err != nil {
+			return 0, "", false
+		}
+		userId, ok = data.Static.Db.FindUserByChatId(chatId)
+		return userId, arguments, ok
+	}
+
+	questionId, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	userId, err = data.Static.Db.GetAuthor(questionId)
+	if err != nil {
+		return 0, "", false
+	}
+	return userId, arguments, true
+}
+
+// parseBanArguments parses "[duration] [purge] [reason]", without the duration the ban is permanent,
+// the duration needs a unit ("30m", "12h", "7d") so a reason starting with a number isn't taken for it
+func parseBanArguments(arguments string) (bannedUntil int64, isPurge bool, reason string) {
+	reason = strings.TrimSpace(arguments)
+	for reason != "" {
+		fields := strings.SplitN(reason, " ", 2)
+		hasUnit := strings.ContainsAny(fields[0][len(fields[0])-1:], "mhd")
+		if duration, ok := processing.ParseDuration(fields[0]); ok && hasUnit && bannedUntil == 0 {
+			bannedUntil = time.Now().Add(duration).Unix()
+		} else if fields[0] == "purge" && !isPurge {
+			isPurge = true
+		} else {
+			break
+		}
+
+		if len(fields) > 1 {
+			reason = strings.TrimSpace(fields[1])
+		} else {
+			reason = ""
+		}
+	}
+	return
+}
+
+// purgeUserContent removes open questions of the user and their answers to the questions that are still open
+func purgeUserContent(staticData *processing.StaticProccessStructs, userId int64) {
+	for _, questionId := range staticData.Db.GetUserOpenQuestions(userId) {
+		processing.RemoveActiveQuestion(staticData, questionId)
+		staticData.Db.RemoveQuestion(questionId)
+		logrus.WithField("question_id", questionId).Info("Question of banned user removed")
+	}
+
+	affectedQuestions := staticData.Db.RemoveUserAnswersFromOpenQuestions(userId)
+	for _, questionId := range affectedQuestions {
+		processing.ProcessCompleteness(staticData, questionId)
+	}
+}
+
+func moderatorBanCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	userId, arguments, ok := parseTargetUser(data)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "user not found, use: /m_ban <questionId|u<userId>|c<chatId>> [duration] [purge] [reason]")
+		return
+	}
+
+	// moderators can't ban themselves and the users with the same or higher role
+	if data.Static.Db.GetUserRole(userId) >= data.Static.Db.GetUserRole(data.UserId) {
+		data.Log.WithField("target_user_id", userId).Warning("Refused to ban a user with the same or higher role")
+		data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("can't ban u%d, the role is not lower than yours", userId))
+		return
+	}
+
+	bannedUntil, isPurge, reason := parseBanArguments(arguments)
+
+	data.Static.Db.BanUser(userId, reason, bannedUntil)
+	data.Log.WithFields(logrus.Fields{
+		"target_user_id": userId,
+		"banned_until":   bannedUntil,
+		"reason":         reason,
+		"purge":          isPurge,
+	}).Info("User banned")
+
+	action := "ban"
+	if bannedUntil != 0 {
+		action = "ban until " + time.Unix(bannedUntil, 0).UTC().Format(logTimeFormat)
+	}
+	processing.LogModeratorAction(data, action, fmt.Sprintf("u%d", userId), reason)
+
+	if isPurge {
+		purgeUserContent(data.Static, userId)
+		processing.LogModeratorAction(data, "purge", fmt.Sprintf("u%d", userId), "")
+	}
+
+	targetChatId := data.Static.Db.GetUserChatId(userId)
+	if bannedUntil != 0 {
+		data.Static.Chat.SendMessage(targetChatId, data.Static.Trans("say_youre_banned_until", map[string]interface{}{
+			"Time": processing.FormatTime(bannedUntil, data.Static.Db.GetUserTimezone(userId)),
+		}))
+	} else {
+		data.Static.Chat.SendMessage(targetChatId, data.Static.Trans("say_youre_banned"))
+	}
+	if reason != "" {
+		data.Static.Chat.SendMessage(targetChatId, data.Static.Trans("say_ban_reason", map[string]interface{}{
+			"Reason": processing.SanitizeText(reason),
+		}))
+	}
+
+	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("banned: u%d", userId))
+}
+
+func moderatorUnbanCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	userId, _, ok := parseTargetUser(data)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "user not found, use: /m_unban <questionId|u<userId>|c<chatId>>")
+		return
+	}
+
+	if !data.Static.Db.IsUserBanned(userId) {
+		data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("not banned: u%d", userId))
+		return
+	}
+
+	data.Static.Db.UnbanUser(userId)
+	data.Log.WithField("target_user_id", userId).Info("User unbanned")
+	processing.LogModeratorAction(data, "unban", fmt.Sprintf("u%d", userId), "")
+	data.Static.Chat.SendMessage(data.Static.Db.GetUserChatId(userId), data.Static.Trans("say_youre_unbanned"))
+	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("unbanned: u%d", userId))
+}
+
+func moderatorBansCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	bans := data.Static.Db.GetBannedUsers()
+	if len(bans) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, "no banned users")
+		return
+	}
+
+	timezone := data.Static.Db.GetUserTimezone(data.UserId)
+	var buffer bytes.Buffer
+	for _, ban := range bans {
+		buffer.WriteString(fmt.Sprintf("u%d", ban.UserId))
+		if ban.BannedUntil != 0 {
+			buffer.WriteString(" until " + processing.FormatTime(ban.BannedUntil, timezone))
+		}
+		if ban.Reason != "" {
+			buffer.WriteString(" - " + processing.SanitizeText(ban.Reason))
+		}
+		buffer.WriteString("\n")
+	}
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
+
+func moderatorRemoveCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	fields := strings.SplitN(strings.TrimSpace(data.Message), " ", 2)
+	questionId, err := strconv.ParseInt(fields[0], 10, 64)
+
+	if err != nil {
+		return
+	}
+
+	var reason string
+	if len(fields) > 1 {
+		reason = strings.TrimSpace(fields[1])
+	}
+
+	processing.RemoveActiveQuestion(data.Static, questionId)
+	data.Static.Db.RemoveQuestion(questionId)
+	data.Log.WithField("question_id", questionId).Info("Question removed")
+	processing.LogModeratorAction(data, "rm", fmt.Sprintf("q%d", questionId), reason)
+	data.Static.Chat.SendMessage(data.ChatId, "removed")
+}
+
+func parseRole(name string) (role int, ok bool) {
+	switch name {
+	case "moderator":
+		return database.RoleModerator, true
+	case "admin":
+		return database.RoleAdmin, true
+	default:
+		return database.RoleUser, false
+	}
+}
+
+func getRoleName(role int) string {
+	switch role {
+	case database.RoleModerator:
+		return "moderator"
+	case database.RoleAdmin:
+		return "admin"
+	default:
+		return "user"
+	}
+}
+
+func adminGrantCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	userId, arguments, ok := parseTargetUser(data)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "user not found, use: /m_grant <questionId|u<userId>|c<chatId>> <moderator|admin>")
+		return
+	}
+
+	role, ok := parseRole(arguments)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "unknown role, use: /m_grant <questionId|u<userId>|c<chatId>> <moderator|admin>")
+		return
+	}
+
+	if role != database.RoleAdmin && isConfigAdmin(data, userId) {
+		data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("u%d is an admin from the config, remove them from the config to change the role", userId))
+		return
+	}
+
+	data.Static.Db.SetUserRole(userId, role)
+	data.Log.WithFields(logrus.Fields{
+		"target_user_id": userId,
+		"role":           getRoleName(role),
+	}).Info("Role granted")
+	processing.LogModeratorAction(data, "grant "+getRoleName(role), fmt.Sprintf("u%d", userId), "")
+	data.Static.Chat.SendMessage(data.Static.Db.GetUserChatId(userId), data.Static.Trans("say_role_granted_"+getRoleName(role)))
+	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("%s: u%d", getRoleName(role), userId))
+}
+
+// isConfigAdmin checks whether the user is listed in the config, such users get the admin role back on every start
+func isConfigAdmin(data *processing.ProcessData, userId int64) bool {
+	chatId := data.Static.Db.GetUserChatId(userId)
+	for _, adminChatId := range data.Static.Config.Moderators {
+		if adminChatId == chatId {
 			return true
 		}
 	}
-
 	return false
 }
 
-func processCompleteness(staticData *processing.StaticProccessStructs, questionId int64) {
-	if isQuestionReadyToBeCompleted(staticData, questionId) {
-		completeQuestion(staticData, questionId)
+func adminRevokeCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	userId, _, ok := parseTargetUser(data)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "user not found, use: /m_revoke <questionId|u<userId>|c<chatId>>")
+		return
 	}
-}
 
-func getDificientDataForQuestionText(staticData *processing.StaticProccessStructs, questionId int64) string {
-	minAnswers, maxAnswers, endTime := staticData.Db.GetQuestionRules(questionId)
-	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+	// don't let admins lock themselves out
+	if userId == data.UserId {
+		data.Static.Chat.SendMessage(data.ChatId, "you can't revoke your own role")
+		return
+	}
 
-	// recalculate currently deficient values
-	minAnswers = minAnswers - answersCount
-	maxAnswers = maxAnswers - answersCount
-	var timeHours int64
-	if endTime > 0 {
-		timeHours = int64(time.Unix(endTime, 0).Sub(time.Now()).Hours() + 1)
+	if isConfigAdmin(data, userId) {
+		data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("u%d is an admin from the config, remove them from the config to revoke the role", userId))
+		return
 	}
 
-	if minAnswers < 0 {
-		minAnswers = 0
+	if data.Static.Db.GetUserRole(userId) == database.RoleUser {
+		data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("no role: u%d", userId))
+		return
 	}
 
-	if maxAnswers < 0 {
-		maxAnswers = 0
+	data.Static.Db.SetUserRole(userId, database.RoleUser)
+	data.Log.WithField("target_user_id", userId).Info("Role revoked")
+	processing.LogModeratorAction(data, "revoke", fmt.Sprintf("u%d", userId), "")
+	data.Static.Chat.SendMessage(data.Static.Db.GetUserChatId(userId), data.Static.Trans("say_role_revoked"))
+	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("revoked: u%d", userId))
+}
+
+func adminRolesCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	roles := data.Static.Db.GetUsersWithRoles()
+
+	userIds := make([]int64, 0, len(roles))
+	for userId := range roles {
+		userIds = append(userIds, userId)
 	}
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
 
-	if timeHours < 0 {
-		timeHours = 0
+	var buffer bytes.Buffer
+	for _, userId := range userIds {
+		buffer.WriteString(fmt.Sprintf("u%d - %s\n", userId, getRoleName(roles[userId])))
 	}
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
 
-	return processing.GetQuestionRulesText(minAnswers, maxAnswers, timeHours, "delta_answers", staticData.Trans)
+func moderatorSendCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	data.Log.WithField("text", getLoggedText(data.Message, data.Static.Config)).Info("Sending message to all users")
+	chatIds := data.Static.Db.GetAllUsersChatIds()
+	data.Static.Chat.BroadcastMessage(chatIds, data.Message)
+	processing.LogModeratorAction(data, "send", "all", data.Message)
 }
 
-func sendAnswerFeedback(data *processing.ProcessData, questionId int64) {
-	if isQuestionReadyToBeCompleted(data.Static, questionId) {
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_answer_added"))
+func moderatorTemplatesCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	templates := data.Static.Db.GetQuestionTemplates(data.Static.Config.Language)
+	if len(templates) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, "no templates, use: /m_template_add <min> <max> <hours> <name>\n<variant>\n<variant>...")
 		return
 	}
 
-	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_answer_added")+"\n"+getDificientDataForQuestionText(data.Static, questionId))
+	var buffer bytes.Buffer
+	for _, template := range templates {
+		buffer.WriteString(fmt.Sprintf("%d - %s (%s) %s\n",
+			template.Id,
+			template.Name,
+			strings.Join(template.Variants, ", "),
+			processing.GetQuestionRulesText(template.MinAnswers, template.MaxAnswers, template.Hours, "answers", data.Static.Trans),
+		))
+	}
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
 }
 
-func parseAnswer(data *processing.ProcessData) {
-	questionId := data.Static.Db.GetUserNextQuestion(data.UserId)
-	variantsCount := data.Static.Db.GetQuestionVariantsCount(questionId)
-
-	if data.Command == "skip" {
-		data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_skipped"))
-
-		processCompleteness(data.Static, questionId)
+// moderatorTemplateAddCommand adds a template, the first line is "<min> <max> <hours> <name>",
+// the next lines are the variants
+func moderatorTemplateAddCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	usage := "use: /m_template_add <min> <max> <hours> <name>\n<variant>\n<variant>..."
 
-		processing.ProcessNextQuestion(data)
+	lines := strings.SplitN(data.Message, "\n", 2)
+	fields := strings.SplitN(strings.TrimSpace(lines[0]), " ", 4)
+	if len(lines) < 2 || len(fields) < 4 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
 		return
 	}
 
-	if !strings.HasPrefix(data.Command, "ans") {
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+	var template database.QuestionTemplate
+	var err error
+	if template.MinAnswers, err = strconv.Atoi(fields[0]); err != nil || template.MinAnswers < 0 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
 		return
 	}
-
-	answer, err := strconv.ParseInt(data.Command[3:len(data.Command)], 10, 64)
-	if err != nil {
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+	if template.MaxAnswers, err = strconv.Atoi(fields[1]); err != nil || template.MaxAnswers < 0 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
+		return
+	}
+	if template.Hours, err = strconv.ParseInt(fields[2], 10, 64); err != nil || template.Hours < 0 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
 		return
 	}
 
-	answer -= 1
-
-	if answer >= 0 && int(answer) < variantsCount {
-		data.Static.Db.AddQuestionAnswer(questionId, data.UserId, answer)
-		data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
-
-		sendAnswerFeedback(data, questionId)
-
-		processCompleteness(data.Static, questionId)
-
-		processing.ProcessNextQuestion(data)
-	} else {
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_wrong_answer"))
+	template.Name = processing.SanitizeText(fields[3])
+	template.Variants = parseVariants(&lines[1])
+	if template.MinAnswers == 0 && template.MaxAnswers == 0 && template.Hours == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
+		return
 	}
-}
 
-func sendEditingGuide(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	dialog := dialogManager.MakeDialog("ed", data)
-	if dialog != nil {
-		data.Static.Chat.SendDialog(dialog, data.ChatId)
+	if template.Name == "" || len(template.Variants) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, usage)
+		return
 	}
+
+	data.Static.Db.AddQuestionTemplate(template, data.Static.Config.Language)
+	processing.LogModeratorAction(data, "template_add", template.Name, "")
+	moderatorTemplatesCommand(data, dialogManager)
 }
 
-func addQuestionCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	if data.Static.Db.IsUserBanned(data.UserId) {
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_youre_banned"))
+func moderatorTemplateRemoveCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	templateId, err := strconv.ParseInt(strings.TrimSpace(data.Message), 10, 64)
+	if err != nil {
+		data.Static.Chat.SendMessage(data.ChatId, "use: /m_template_remove <id>")
 		return
 	}
-	if !data.Static.Db.IsUserEditingQuestion(data.UserId) {
-		data.Static.Db.StartCreatingQuestion(data.UserId)
-		data.Static.Db.UnmarkUserReady(data.UserId)
-		data.Static.UserStates[data.ChatId] = processing.WaitingText
-		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("ask_question_text"))
-	} else {
-		sendEditingGuide(data, dialogManager)
+
+	template, ok := data.Static.Db.GetQuestionTemplate(templateId)
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, "no such template")
+		return
 	}
+
+	data.Static.Db.RemoveQuestionTemplate(templateId)
+	processing.LogModeratorAction(data, "template_remove", template.Name, "")
+	data.Static.Chat.SendMessage(data.ChatId, "removed")
 }
 
-func startCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("hello_message"))
-	if !data.Static.Db.IsUserHasPendingQuestions(data.UserId) {
-		data.Static.Db.InitNewUserQuestions(data.UserId)
-		data.Static.Db.UnmarkUserReady(data.UserId)
-		processing.ProcessNextQuestion(data)
+func formatModerationLogRecord(record database.ModerationLogRecord, timezone string) string {
+	text := fmt.Sprintf("#%d %s u%d %s %s", record.Id, processing.FormatTime(record.Time, timezone), record.ModeratorId, record.Action, record.Target)
+	if record.Reason != "" {
+		text += " - " + processing.SanitizeText(record.Reason)
 	}
+	return text
 }
 
-func lastResultsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	questions := data.Static.Db.GetLastFinishedQuestions(10)
-	for _, questionId := range questions {
-		sendResults(data.Static, questionId, []int64{data.ChatId})
+func moderatorLogCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	page := 1
+	if data.Message != "" {
+		var err error
+		page, err = strconv.Atoi(strings.TrimSpace(data.Message))
+		if err != nil || page < 1 {
+			data.Static.Chat.SendMessage(data.ChatId, "use: /m_log [page]")
+			return
+		}
 	}
-}
 
-func myQuestionsCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	questionsIds := data.Static.Db.GetUserLastQuestions(data.UserId, 10)
-	finishedQuestionsIds := data.Static.Db.GetUserLastFinishedQuestions(data.UserId, 10)
+	pagesCount := (data.Static.Db.GetModerationLogSize() + moderationLogPageSize - 1) / moderationLogPageSize
+	records := data.Static.Db.GetModerationLog((page-1)*moderationLogPageSize, moderationLogPageSize)
+	if len(records) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, "no records")
+		return
+	}
 
-	finishedQuestionsMap := make(map[int64]bool)
-	for _, questionId := range finishedQuestionsIds {
-		finishedQuestionsMap[questionId] = true
+	timezone := data.Static.Db.GetUserTimezone(data.UserId)
+	var buffer bytes.Buffer
+	for _, record := range records {
+		buffer.WriteString(formatModerationLogRecord(record, timezone) + "\n")
 	}
 
-	for _, questionId := range questionsIds {
-		if _, ok := finishedQuestionsMap[questionId]; ok {
-			sendResults(data.Static, questionId, []int64{data.ChatId})
-		} else {
-			data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("<i>%s</i>\n%s",
-				data.Static.Db.GetQuestionText(questionId),
-				getDificientDataForQuestionText(data.Static, questionId),
-			))
-		}
+	buffer.WriteString(fmt.Sprintf("\npage %d of %d", page, pagesCount))
+	if page < pagesCount {
+		buffer.WriteString(fmt.Sprintf(", next: /m_log %d", page+1))
 	}
+	buffer.WriteString("\nexport: /m_log_export")
+
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
 }
 
-func moderatorListCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	questions := data.Static.Db.GetLastPublishedQuestions(15)
+func moderatorLogExportCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
 	var buffer bytes.Buffer
-	for _, question := range questions {
-		buffer.WriteString(fmt.Sprintf("%d - %s\n", question, data.Static.Db.GetQuestionText(question)))
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"id", "time", "moderator_id", "action", "target", "reason"})
+
+	records := data.Static.Db.GetModerationLog(0, data.Static.Db.GetModerationLogSize())
+	for _, record := range records {
+		writer.Write([]string{
+			strconv.FormatInt(record.Id, 10),
+			time.Unix(record.Time, 0).UTC().Format(time.RFC3339),
+			strconv.FormatInt(record.ModeratorId, 10),
+			record.Action,
+			record.Target,
+			record.Reason,
+		})
 	}
-	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
-}
+	writer.Flush()
 
-func moderatorBanCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	questionId, err := strconv.ParseInt(data.Message, 10, 64)
+	data.Log.Info("Moderation log exported")
+	data.Static.Chat.SendDocument(data.ChatId, "moderation_log.csv", buffer.Bytes())
+}
 
-	if err != nil {
-		return
+func parseVariants(message *string) (variants []string) {
+	for _, line := range strings.Split(*message, "\n") {
+		variant := processing.SanitizeText(line)
+		if variant != "" {
+			variants = append(variants, variant)
+		}
 	}
+	return
+}
 
-	author, err := data.Static.Db.GetAuthor(questionId)
-	if err != nil {
-		return
+func setVariants(db *database.Database, questionId int64, message *string) (ok bool) {
+	variants := parseVariants(message)
+	if len(variants) == 0 {
+		return false
 	}
-	data.Static.Db.BanUser(author)
-	data.Static.Chat.SendMessage(data.ChatId, fmt.Sprintf("banned: %d", author))
+
+	db.SetQuestionVariants(questionId, variants)
+	return true
 }
 
-func moderatorRemoveCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	questionId, err := strconv.ParseInt(data.Message, 10, 64)
+// setScale parses the scale in the format "<min> <max>"
+func setScale(db *database.Database, questionId int64, message *string) (ok bool) {
+	fields := strings.Fields(*message)
+	if len(fields) != 2 {
+		return false
+	}
 
+	minValue, err := strconv.Atoi(fields[0])
 	if err != nil {
-		return
+		return false
 	}
 
-	removeActiveQuestion(data.Static, questionId)
-	data.Static.Db.RemoveQuestion(questionId)
-	data.Static.Chat.SendMessage(data.ChatId, "removed")
-}
-
-func moderatorSendCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
-	chatIds := data.Static.Db.GetAllUsersChatIds()
-	for _, chatId := range chatIds {
-		data.Static.Chat.SendMessage(chatId, data.Message)
+	maxValue, err := strconv.Atoi(fields[1])
+	if err != nil || maxValue <= minValue || maxValue-minValue >= maxScaleVariants {
+		return false
 	}
-}
 
-func setVariants(db *database.Database, questionId int64, message *string) (ok bool) {
-	variants := strings.Split(*message, "\n")
-	db.SetQuestionVariants(questionId, variants)
+	db.SetQuestionScale(questionId, minValue, maxValue)
 	return true
 }
 
@@ -338,19 +1396,35 @@ func setRules(db *database.Database, questionId int64, message *string) (ok bool
 
 func makeUserCommandProcessors() ProcessorFuncMap {
 	return map[string]ProcessorFunc{
-		"start":        startCommand,
-		"add_question": addQuestionCommand,
-		"last_results": lastResultsCommand,
-		"my_questions": myQuestionsCommand,
+		"start":                startCommand,
+		"add_question":         addQuestionCommand,
+		"last_results":         lastResultsCommand,
+		"my_questions":         myQuestionsCommand,
+		"stop":                 stopCommand,
+		"pause":                pauseCommand,
+		"timezone":             timezoneCommand,
+		"quiet":                quietHoursCommand,
+		"result_notifications": resultNotificationsCommand,
+		"digest":               digestCommand,
+		"pending":              pendingCommand,
+		"report":               reportCommand,
+		"results":              resultsCommand,
 	}
 }
 
 func makeModeratorCommandProcessors() ProcessorFuncMap {
 	return map[string]ProcessorFunc{
-		"m_list": moderatorListCommand,
-		"m_ban":  moderatorBanCommand,
-		"m_rm":   moderatorRemoveCommand,
-		"m_send": moderatorSendCommand,
+		"m_list":            moderatorListCommand,
+		"m_ban":             moderatorBanCommand,
+		"m_unban":           moderatorUnbanCommand,
+		"m_bans":            moderatorBansCommand,
+		"m_rm":              moderatorRemoveCommand,
+		"m_send":            moderatorSendCommand,
+		"m_log":             moderatorLogCommand,
+		"m_log_export":      moderatorLogExportCommand,
+		"m_templates":       moderatorTemplatesCommand,
+		"m_template_add":    moderatorTemplateAddCommand,
+		"m_template_remove": moderatorTemplateRemoveCommand,
 	}
 }
 
@@ -362,9 +1436,18 @@ func processAnswer(data *processing.ProcessData) bool {
 	return false
 }
 
+func makeAdminCommandProcessors() ProcessorFuncMap {
+	return map[string]ProcessorFunc{
+		"m_grant":  adminGrantCommand,
+		"m_revoke": adminRevokeCommand,
+		"m_roles":  adminRolesCommand,
+	}
+}
+
 func processCommandByProcessors(data *processing.ProcessData, processorsMap ProcessorFuncMap, dialogManager *dialogFactories.DialogManager) bool {
 	processor, ok := processorsMap[data.Command]
 	if ok {
+		metrics.CountProcessedUpdate(data.Command)
 		processor(data, dialogManager)
 	}
 
@@ -372,18 +1455,33 @@ func processCommandByProcessors(data *processing.ProcessData, processorsMap Proc
 }
 
 func processCommand(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager, processors *Processors) {
-	if strings.HasPrefix(data.Command, "m_") && isUserModerator(data.ChatId, data.Static.Config) {
-		processed := processCommandByProcessors(data, processors.Moderator, dialogManager)
-		if processed {
-			return
+	if strings.HasPrefix(data.Command, "m_") {
+		if processing.IsUserAdmin(data) {
+			processed := processCommandByProcessors(data, processors.Admin, dialogManager)
+			if processed {
+				return
+			}
+		}
+		if processing.IsUserModerator(data) {
+			processed := processCommandByProcessors(data, processors.Moderator, dialogManager)
+			if processed {
+				return
+			}
 		}
 	}
 
 	ids := strings.Split(data.Command, "_")
-	if len(ids) >= 2 {
-		processed := dialogManager.ProcessVariant(ids[0], ids[1], data)
-		if processed {
-			return
+	if len(ids) == 2 || len(ids) == 3 {
+		var additionalId int64
+		var err error
+		if len(ids) == 3 {
+			additionalId, err = strconv.ParseInt(ids[2], 10, 64)
+		}
+		if err == nil {
+			processed := dialogManager.ProcessVariant(ids[0], ids[1], additionalId, data)
+			if processed {
+				return
+			}
 		}
 	}
 
@@ -392,6 +1490,36 @@ func processCommand(data *processing.ProcessData, dialogManager *dialogFactories
 		return
 	}
 
+	processed = processDirectAnswer(data)
+	if processed {
+		return
+	}
+
+	processed = processFinishRanking(data)
+	if processed {
+		return
+	}
+
+	processed = processJumpToQuestion(data)
+	if processed {
+		return
+	}
+
+	processed = processReportQuestion(data, dialogManager)
+	if processed {
+		return
+	}
+
+	processed = processReuseQuestion(data, dialogManager)
+	if processed {
+		return
+	}
+
+	processed = processApplyTemplate(data, dialogManager)
+	if processed {
+		return
+	}
+
 	isEditingQuestion := data.Static.Db.IsUserEditingQuestion(data.UserId)
 	if !isEditingQuestion {
 		processed = processAnswer(data)
@@ -401,26 +1529,17 @@ func processCommand(data *processing.ProcessData, dialogManager *dialogFactories
 	}
 
 	// if we here it means that no command was processed
+	data.Log.Debug("Unknown command")
 	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
 	if isEditingQuestion {
 		sendEditingGuide(data, dialogManager)
 	}
 }
 
-func isUserModerator(chatId int64, config *processing.StaticConfiguration) bool {
-	for _, moderator := range config.Moderators {
-		if chatId == moderator {
-			return true
-		}
-	}
-
-	return false
-}
-
 func processSetTextContent(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
-		data.Static.Db.SetQuestionText(questionId, data.Message)
+		data.Static.Db.SetQuestionText(questionId, processing.SanitizeText(data.Message))
 		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_text_is_set"))
 		sendEditingGuide(data, dialogManager)
 		delete(data.Static.UserStates, data.ChatId)
@@ -447,6 +1566,23 @@ func processSetVariantsContent(data *processing.ProcessData, dialogManager *dial
 	}
 }
 
+func processSetScaleContent(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
+		questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
+		ok := setScale(data.Static.Db, questionId, &data.Message)
+		if ok {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_variants_is_set"))
+			sendEditingGuide(data, dialogManager)
+			delete(data.Static.UserStates, data.ChatId)
+		} else {
+			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_scale", maxScaleVariants))
+		}
+	} else {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
+		delete(data.Static.UserStates, data.ChatId)
+	}
+}
+
 func processSetRulesContent(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
 	if data.Static.Db.IsUserEditingQuestion(data.UserId) {
 		questionId := data.Static.Db.GetUserEditingQuestion(data.UserId)
@@ -464,6 +1600,96 @@ func processSetRulesContent(data *processing.ProcessData, dialogManager *dialogF
 	}
 }
 
+// getEditedPublishedQuestion returns the open question that the author is editing
+func getEditedPublishedQuestion(data *processing.ProcessData) (questionId int64, ok bool) {
+	questionId, ok = data.Static.EditedQuestions[data.ChatId]
+	if !ok {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
+		return
+	}
+
+	if data.Static.Db.GetQuestionStatus(questionId) != database.QuestionStatusOpen {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_outdated"))
+		return questionId, false
+	}
+
+	return
+}
+
+func stopEditingPublishedQuestion(data *processing.ProcessData) {
+	delete(data.Static.UserStates, data.ChatId)
+	delete(data.Static.EditedQuestions, data.ChatId)
+}
+
+func sendEditViolations(data *processing.ProcessData, violations []string) {
+	var buffer bytes.Buffer
+	buffer.WriteString(data.Static.Trans("warn_edit_violates_policy"))
+	for _, violation := range violations {
+		buffer.WriteString("\n- " + violation)
+	}
+	data.Static.Chat.SendMessage(data.ChatId, buffer.String())
+}
+
+func finishEditingPublishedQuestion(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager, questionId int64) {
+	stopEditingPublishedQuestion(data)
+	data.Static.Chat.UpdateQuestion(data.Static.Db, questionId)
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_updated"))
+	data.Static.Chat.SendDialog(dialogManager.MakeDialog("aq", questionId, data), data.ChatId)
+}
+
+func processEditPublishedText(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questionId, ok := getEditedPublishedQuestion(data)
+	if !ok {
+		stopEditingPublishedQuestion(data)
+		return
+	}
+
+	text := processing.SanitizeText(data.Message)
+	if violations := processing.GetContentViolations(data, text, nil); len(violations) > 0 {
+		sendEditViolations(data, violations)
+		return
+	}
+
+	// the users that already answered should know that the text is not the one they saw
+	if data.Static.Db.GetQuestionAnswersCount(questionId) > 0 {
+		data.Static.Db.MarkQuestionEdited(questionId)
+	}
+	data.Static.Db.SetQuestionText(questionId, text)
+	data.Log.WithField("question_id", questionId).Info("Published question text edited")
+
+	finishEditingPublishedQuestion(data, dialogManager, questionId)
+}
+
+func processEditPublishedVariants(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
+	questionId, ok := getEditedPublishedQuestion(data)
+	if !ok {
+		stopEditingPublishedQuestion(data)
+		return
+	}
+
+	if data.Static.Db.GetQuestionAnswersCount(questionId) > 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_question_has_answers"))
+		stopEditingPublishedQuestion(data)
+		return
+	}
+
+	variants := parseVariants(&data.Message)
+	if len(variants) == 0 {
+		data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_bad_variants"))
+		return
+	}
+
+	if violations := processing.GetContentViolations(data, "", variants); len(violations) > 0 {
+		sendEditViolations(data, violations)
+		return
+	}
+
+	data.Static.Db.SetQuestionVariants(questionId, variants)
+	data.Log.WithField("question_id", questionId).Info("Published question variants edited")
+
+	finishEditingPublishedQuestion(data, dialogManager, questionId)
+}
+
 func processPlainMessage(data *processing.ProcessData, dialogManager *dialogFactories.DialogManager) {
 	if userState, ok := data.Static.UserStates[data.ChatId]; ok {
 		switch userState {
@@ -473,6 +1699,12 @@ func processPlainMessage(data *processing.ProcessData, dialogManager *dialogFact
 			processSetVariantsContent(data, dialogManager)
 		case processing.WaitingRules:
 			processSetRulesContent(data, dialogManager)
+		case processing.WaitingScale:
+			processSetScaleContent(data, dialogManager)
+		case processing.WaitingPublishedText:
+			processEditPublishedText(data, dialogManager)
+		case processing.WaitingPublishedVariants:
+			processEditPublishedVariants(data, dialogManager)
 		default:
 			data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("warn_unknown_command"))
 			delete(data.Static.UserStates, data.ChatId)
@@ -489,11 +1721,30 @@ func processUpdate(update *tgbotapi.Update, staticData *processing.StaticProcces
 	data := processing.ProcessData{
 		Static: staticData,
 		ChatId: update.Message.Chat.ID,
-		UserId: staticData.Db.GetUserId(update.Message.Chat.ID),
+		Log: logrus.WithFields(logrus.Fields{
+			"update_id": update.UpdateID,
+			"chat_id":   update.Message.Chat.ID,
+		}),
 	}
 
+	// the database errors are logged with the context of the update before exiting
+	defer func() {
+		exitOnPanic(data.Log, recover())
+	}()
+
+	data.UserId = staticData.Db.GetUserId(data.ChatId)
+
 	message := update.Message.Text
 
+	if update.Message.From != nil {
+		displayName := strings.TrimSpace(update.Message.From.FirstName + " " + update.Message.From.LastName)
+		staticData.Db.SetUserNames(data.UserId, displayName, update.Message.From.UserName)
+	}
+
+	if update.Message.ReplyToMessage != nil && update.Message.ReplyToMessage.ForwardFrom != nil {
+		data.RepliedForwardChatId = int64(update.Message.ReplyToMessage.ForwardFrom.ID)
+	}
+
 	if strings.HasPrefix(message, "/") {
 		commandLen := strings.Index(message, " ")
 		if commandLen != -1 {
@@ -502,14 +1753,63 @@ func processUpdate(update *tgbotapi.Update, staticData *processing.StaticProcces
 		} else {
 			data.Command = message[1:]
 		}
+	} else {
+		data.Message = message
+	}
 
+	data.Log = data.Log.WithFields(logrus.Fields{
+		"user_id": data.UserId,
+		"command": data.Command,
+	})
+
+	data.Log.WithField("text", getLoggedText(data.Message, staticData.Config)).Debug("Processing update")
+
+	if data.Command != "" {
 		processCommand(&data, dialogManager, processors)
 	} else {
-		data.Message = message
 		processPlainMessage(&data, dialogManager)
 	}
 }
 
+func getLoggedText(text string, config *processing.StaticConfiguration) string {
+	if config.PrivacyMode && text != "" {
+		return "[redacted]"
+	}
+	return text
+}
+
+// makeUserProcessData prepares data to process events that aren't initiated by the user
+func makeUserProcessData(staticData *processing.StaticProccessStructs, userId int64) processing.ProcessData {
+	chatId := staticData.Db.GetUserChatId(userId)
+	return processing.ProcessData{
+		Static: staticData,
+		ChatId: chatId,
+		UserId: userId,
+		Log: logrus.WithFields(logrus.Fields{
+			"chat_id": chatId,
+			"user_id": userId,
+		}),
+	}
+}
+
+func processExpiredPauses(staticData *processing.StaticProccessStructs, dialogManager *dialogFactories.DialogManager) {
+	users := staticData.Db.GetUsersWithExpiredPause(time.Now().Unix())
+	for _, userId := range users {
+		data := makeUserProcessData(staticData, userId)
+		resumeSubscription(&data, dialogManager)
+	}
+}
+
+func processExpiredBans(staticData *processing.StaticProccessStructs) {
+	users := staticData.Db.GetUsersWithExpiredBan(time.Now().Unix())
+	for _, userId := range users {
+		staticData.Db.UnbanUser(userId)
+		logrus.WithField("target_user_id", userId).Info("Ban expired")
+		staticData.Chat.SendMessage(staticData.Db.GetUserChatId(userId), staticData.Trans("say_youre_unbanned"))
+	}
+}
+
 func processTimer(staticData *processing.StaticProccessStructs, questionId int64) {
-	processCompleteness(staticData, questionId)
+	logrus.WithField("question_id", questionId).Debug("Processing question timer")
+	processing.ProcessCompleteness(staticData, questionId)
 }
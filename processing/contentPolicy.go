@@ -0,0 +1,143 @@
+package processing
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ContentViolation describes why a question can't be published
+type ContentViolation struct {
+	TranslationId string
+	Args          map[string]interface{}
+}
+
+// ContentPolicy checks the questions before they are sent to the users
+type ContentPolicy interface {
+	CheckQuestionText(text string) []ContentViolation
+	CheckVariants(variants []string) []ContentViolation
+}
+
+var urlRegexp = regexp.MustCompile(`(?i)(https?://|www\.|t\.me/|telegram\.me/)\S+`)
+
+// configContentPolicy is the content policy with the limits from the configuration file
+type configContentPolicy struct {
+	config *StaticConfiguration
+}
+
+func MakeConfigContentPolicy(config *StaticConfiguration) ContentPolicy {
+	return &configContentPolicy{
+		config: config,
+	}
+}
+
+// SanitizeText prepares the text written by a user to be sent as a part of an HTML message
+func SanitizeText(text string) string {
+	return html.EscapeString(strings.TrimSpace(text))
+}
+
+// normalizeWords lowers the case of the text and leaves only its words separated by single spaces
+func normalizeWords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
+
+func (policy *configContentPolicy) checkString(text string) (violations []ContentViolation) {
+	if policy.config.BlockUrls && urlRegexp.MatchString(text) {
+		violations = append(violations, ContentViolation{TranslationId: "violation_urls_not_allowed"})
+	}
+
+	if len(policy.config.StopWords) > 0 {
+		// stop words can be phrases, they are matched by whole words ignoring punctuation and case
+		normalizedText := " " + normalizeWords(text) + " "
+
+		for _, stopWord := range policy.config.StopWords {
+			normalizedStopWord := normalizeWords(stopWord)
+			if normalizedStopWord != "" && strings.Contains(normalizedText, " "+normalizedStopWord+" ") {
+				violations = append(violations, ContentViolation{
+					TranslationId: "violation_stop_word",
+					Args:          map[string]interface{}{"Word": html.EscapeString(normalizedStopWord)},
+				})
+			}
+		}
+	}
+
+	return
+}
+
+func (policy *configContentPolicy) CheckQuestionText(text string) (violations []ContentViolation) {
+	// the texts are stored escaped, limits are applied to what the users see
+	text = html.UnescapeString(text)
+
+	maxLength := policy.config.MaxQuestionTextLength
+	if maxLength > 0 && utf8.RuneCountInString(text) > maxLength {
+		violations = append(violations, ContentViolation{
+			TranslationId: "violation_text_too_long",
+			Args:          map[string]interface{}{"Max": maxLength},
+		})
+	}
+
+	return append(violations, policy.checkString(text)...)
+}
+
+func (policy *configContentPolicy) CheckVariants(variants []string) (violations []ContentViolation) {
+	maxCount := policy.config.MaxVariantsCount
+	if maxCount > 0 && len(variants) > maxCount {
+		violations = append(violations, ContentViolation{
+			TranslationId: "violation_too_many_variants",
+			Args:          map[string]interface{}{"Max": maxCount},
+		})
+	}
+
+	maxLength := policy.config.MaxVariantLength
+	for i, variant := range variants {
+		variant = html.UnescapeString(variant)
+
+		if maxLength > 0 && utf8.RuneCountInString(variant) > maxLength {
+			violations = append(violations, ContentViolation{
+				TranslationId: "violation_variant_too_long",
+				Args:          map[string]interface{}{"Number": i + 1, "Max": maxLength},
+			})
+		}
+
+		violations = append(violations, policy.checkString(variant)...)
+	}
+
+	return
+}
+
+// GetQuestionContentViolations returns the explanations why the question can't be published
+func GetQuestionContentViolations(data *ProcessData, questionId int64) (violations []string) {
+	if data.Static.ContentPolicy == nil {
+		return
+	}
+
+	var text string
+	if data.Static.Db.IsQuestionHasText(questionId) {
+		text = data.Static.Db.GetQuestionText(questionId)
+	}
+	return GetContentViolations(data, text, data.Static.Db.GetQuestionVariants(questionId))
+}
+
+// GetContentViolations checks a text and variants that are not stored yet
+func GetContentViolations(data *ProcessData, text string, variants []string) (violations []string) {
+	policy := data.Static.ContentPolicy
+	if policy == nil {
+		return
+	}
+
+	var found []ContentViolation
+	if text != "" {
+		found = append(found, policy.CheckQuestionText(text)...)
+	}
+	found = append(found, policy.CheckVariants(variants)...)
+
+	for _, violation := range found {
+		violations = append(violations, data.Static.Trans(violation.TranslationId, violation.Args))
+	}
+	return
+}
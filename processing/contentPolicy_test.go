@@ -0,0 +1,32 @@
+package processing
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStopWords(t *testing.T) {
+	assert := require.New(t)
+
+	policy := MakeConfigContentPolicy(&StaticConfiguration{
+		StopWords: []string{"spam", "Buy  now"},
+	})
+
+	testCases := []struct {
+		text      string
+		violation bool
+	}{
+		{"Is it spam?", true},
+		{"SPAM", true},
+		{"spammer", false},
+		{"Should I buy now?", true},
+		{"buy, NOW!", true},
+		{"buy it now", false},
+		{"nowhere to buy", false},
+	}
+
+	for _, testCase := range testCases {
+		violations := policy.CheckQuestionText(testCase.text)
+		assert.Equal(testCase.violation, len(violations) > 0, testCase.text)
+	}
+}
@@ -0,0 +1,67 @@
+package processing
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// LogModeratorAction stores the action in the moderation log that moderators can review with /m_log
+func LogModeratorAction(data *ProcessData, action string, target string, reason string) {
+	data.Static.Db.AddModerationLogRecord(data.UserId, action, target, reason)
+}
+
+// CanUserReportQuestion checks that the user received the question and isn't its author,
+// so a few accounts can't suspend the questions they never saw
+func CanUserReportQuestion(staticData *StaticProccessStructs, userId int64, questionId int64) bool {
+	author, err := staticData.Db.GetAuthor(questionId)
+	if err != nil || author == userId {
+		return false
+	}
+
+	return staticData.Db.IsQuestionPendingForUser(userId, questionId) || staticData.Db.IsQuestionAnsweredByUser(userId, questionId)
+}
+
+// SuspendQuestion hides the question from the users until a moderator reviews it,
+// the questions of a survey are answered one after another so the whole survey is hidden
+func SuspendQuestion(staticData *StaticProccessStructs, questionId int64) {
+	if staticData.Db.GetQuestionStatus(questionId) != database.QuestionStatusOpen {
+		return
+	}
+
+	if surveyId, ok := staticData.Db.GetQuestionSurvey(questionId); ok {
+		suspendSurvey(staticData, surveyId)
+		return
+	}
+
+	withdrawQuestionFromUsers(staticData, questionId)
+	staticData.Db.SuspendQuestion(questionId)
+	logrus.WithField("question_id", questionId).Info("Question suspended")
+}
+
+// RestoreQuestion sends the suspended question to the users again, with the rest of its survey
+func RestoreQuestion(staticData *StaticProccessStructs, questionId int64) {
+	if staticData.Db.GetQuestionStatus(questionId) != database.QuestionStatusSuspended {
+		return
+	}
+
+	if surveyId, ok := staticData.Db.GetQuestionSurvey(questionId); ok {
+		RestoreSurvey(staticData, surveyId)
+		return
+	}
+
+	restoreSuspendedQuestion(staticData, questionId)
+	logrus.WithField("question_id", questionId).Info("Question restored")
+
+	// the users that reported the question don't receive it again
+	broadcastToReadyPendingUsers(staticData, questionId)
+}
+
+func restoreSuspendedQuestion(staticData *StaticProccessStructs, questionId int64) {
+	staticData.Db.RestoreSuspendedQuestion(questionId)
+
+	_, _, endTime := staticData.Db.GetQuestionRules(questionId)
+	if endTime > 0 {
+		staticData.Timers[questionId] = time.Unix(endTime, 0)
+	}
+}
@@ -0,0 +1,111 @@
+package processing
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/dialog"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	testDbPath = "./testDb.db"
+)
+
+// testChat keeps what would be sent to the users
+type testChat struct {
+	messages []string
+	// chat ids of the users that received each question
+	questions map[int64][]int64
+}
+
+func (chat *testChat) SendMessage(chatId int64, message string) {
+	chat.messages = append(chat.messages, message)
+}
+
+func (chat *testChat) BroadcastMessage(chatIds []int64, message string) {
+	chat.messages = append(chat.messages, message)
+}
+
+func (chat *testChat) SendQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+	chat.BroadcastQuestion(db, questionId, usersChatIds)
+}
+
+// BroadcastQuestion changes the state of the users the same way the real chat does
+func (chat *testChat) BroadcastQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+	chat.questions[questionId] = append(chat.questions[questionId], usersChatIds...)
+	db.UnmarkUsersReady(usersChatIds)
+	db.SetUsersCurrentQuestion(usersChatIds, questionId)
+}
+
+func (chat *testChat) UpdateQuestion(db *database.Database, questionId int64) {
+}
+
+func (chat *testChat) SendDialog(dialog *dialog.Dialog, chatId int64) {
+}
+
+func (chat *testChat) SendDocument(chatId int64, fileName string, content []byte) {
+}
+
+func connectTestDb(t *testing.T) *database.Database {
+	os.Remove(testDbPath)
+
+	db := &database.Database{}
+	err := db.Connect(testDbPath)
+	if err != nil {
+		require.New(t).Fail("Problem with creation db connection:" + err.Error())
+		return nil
+	}
+	return db
+}
+
+func disconnectTestDb(db *database.Database) {
+	db.Disconnect()
+	os.Remove(testDbPath)
+}
+
+func makeTestStaticData(db *database.Database) *StaticProccessStructs {
+	return &StaticProccessStructs{
+		Chat:            &testChat{questions: make(map[int64][]int64)},
+		Db:              db,
+		UserStates:      make(map[int64]UserState),
+		Timers:          make(map[int64]time.Time),
+		Config:          &StaticConfiguration{},
+		Trans:           func(translationID string, args ...interface{}) string { return translationID },
+		EditedQuestions: make(map[int64]int64),
+	}
+}
+
+func TestCanUserReportQuestion(t *testing.T) {
+	assert := require.New(t)
+	db := connectTestDb(t)
+	if db == nil {
+		return
+	}
+	defer disconnectTestDb(db)
+	staticData := makeTestStaticData(db)
+
+	authorId := db.GetUserId(121)
+	pendingUserId := db.GetUserId(122)
+	answeredUserId := db.GetUserId(123)
+
+	db.StartCreatingQuestion(authorId)
+	questionId := db.GetUserEditingQuestion(authorId)
+	db.SetQuestionText(questionId, "text")
+	db.SetQuestionVariants(questionId, []string{"a", "b"})
+	db.CommitQuestion(questionId)
+	db.AddQuestionAnswer(questionId, answeredUserId, 0)
+	db.RemoveUserPendingQuestion(answeredUserId, questionId)
+
+	// the user joined after the question was sent
+	newUserId := db.GetUserId(124)
+
+	assert.True(CanUserReportQuestion(staticData, pendingUserId, questionId))
+	assert.True(CanUserReportQuestion(staticData, answeredUserId, questionId))
+	// the author has the question pending too
+	assert.True(db.IsQuestionPendingForUser(authorId, questionId))
+	assert.False(CanUserReportQuestion(staticData, authorId, questionId))
+	assert.False(CanUserReportQuestion(staticData, newUserId, questionId))
+	assert.False(CanUserReportQuestion(staticData, pendingUserId, questionId+100))
+}
@@ -1,9 +1,16 @@
 package processing
 
+import (
+	"github.com/sirupsen/logrus"
+)
+
 type ProcessData struct {
 	Static  *StaticProccessStructs
 	Command string // first part of command without slash(/)
 	Message string // parameters of command or plain message
 	ChatId  int64
 	UserId  int64
+	Log     *logrus.Entry // logger with the context of the processed update
+	// chat id of the author of the forwarded message the user replied to, 0 if there is none
+	RepliedForwardChatId int64
 }
@@ -1,11 +1,17 @@
 package processing
 
 import (
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
 	"github.com/nicksnyder/go-i18n/i18n"
 	"time"
 )
 
 func ProcessNextQuestion(data *ProcessData) {
+	// users in digest mode receive their questions once a day
+	if data.Static.Db.IsUserInDigestMode(data.UserId) {
+		return
+	}
+
 	if data.Static.Db.IsUserHasPendingQuestions(data.UserId) {
 		nextQuestion := data.Static.Db.GetUserNextQuestion(data.UserId)
 		data.Static.Chat.SendQuestion(data.Static.Db, nextQuestion, []int64{data.ChatId})
@@ -14,8 +20,68 @@ func ProcessNextQuestion(data *ProcessData) {
 	}
 }
 
+// withdrawQuestionFromUsers removes the question from the users and sends the next questions
+// to the users that were answering it
+func withdrawQuestionFromUsers(staticData *StaticProccessStructs, questionId int64) {
+	delete(staticData.Timers, questionId)
+
+	users := staticData.Db.GetUsersAnsweringQuestionNow(questionId)
+	for _, user := range users {
+		staticData.Db.RemoveUserPendingQuestion(user, questionId)
+		chatId := staticData.Db.GetUserChatId(user)
+		staticData.Chat.BroadcastMessage([]int64{chatId}, staticData.Trans("say_question_outdated"))
+
+		if staticData.Db.IsUserHasPendingQuestions(user) {
+			staticData.Chat.BroadcastQuestion(staticData.Db, staticData.Db.GetUserNextQuestion(user), []int64{chatId})
+		} else {
+			staticData.Db.MarkUserReady(user)
+		}
+	}
+
+	staticData.Db.RemoveQuestionFromAllUsers(questionId)
+}
+
+func RemoveActiveQuestion(staticData *StaticProccessStructs, questionId int64) {
+	staticData.Db.FinishQuestion(questionId)
+	withdrawQuestionFromUsers(staticData, questionId)
+}
+
+// broadcastToReadyPendingUsers sends the question to the users that are waiting for questions
+// and have it pending
+func broadcastToReadyPendingUsers(staticData *StaticProccessStructs, questionId int64) {
+	isPending := make(map[int64]bool)
+	for _, chatId := range staticData.Db.GetQuestionPendingChatIds(questionId) {
+		isPending[chatId] = true
+	}
+
+	var users []int64
+	for _, chatId := range staticData.Db.GetReadyUsersChatIds() {
+		if isPending[chatId] {
+			users = append(users, chatId)
+		}
+	}
+	staticData.Chat.BroadcastQuestion(staticData.Db, questionId, users)
+}
+
+// ReopenQuestion opens the closed question again with the new rules
+// and sends it to the users that haven't answered it
+func ReopenQuestion(staticData *StaticProccessStructs, questionId int64, minAnswers int, maxAnswers int, endTime time.Time) {
+	staticData.Db.ReopenQuestion(questionId)
+	staticData.Db.SetQuestionRules(questionId, minAnswers, maxAnswers, endTime.Unix())
+	staticData.Timers[questionId] = endTime
+
+	broadcastToReadyPendingUsers(staticData, questionId)
+}
+
 func CommitQuestion(data *ProcessData, questionId int64) {
+	if surveyId, ok := data.Static.Db.GetQuestionSurvey(questionId); ok {
+		commitSurvey(data, surveyId, questionId)
+		return
+	}
+
 	data.Static.Db.CommitQuestion(questionId)
+	metrics.CountCommittedQuestion()
+	data.Log.WithField("question_id", questionId).Info("Question committed")
 	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_question_commited"))
 
 	minAnswers, maxAnswers, durationTime := data.Static.Db.GetQuestionRules(questionId)
@@ -29,7 +95,7 @@ func CommitQuestion(data *ProcessData, questionId int64) {
 
 	users := data.Static.Db.GetReadyUsersChatIds()
 
-	data.Static.Chat.SendQuestion(data.Static.Db, questionId, users)
+	data.Static.Chat.BroadcastQuestion(data.Static.Db, questionId, users)
 }
 
 func GetQuestionRulesText(minAnswers int, maxAnswers int, time int64, answersTag string, trans i18n.TranslateFunc) string {
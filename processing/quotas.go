@@ -0,0 +1,53 @@
+package processing
+
+import (
+	"time"
+)
+
+const commitsCountPeriod = 24 * time.Hour
+
+// GetQuestionQuotaWarning checks whether the user can publish one more question now
+// and returns the text explaining why not, or an empty string if the user can
+func GetQuestionQuotaWarning(data *ProcessData) string {
+	config := data.Static.Config
+	db := data.Static.Db
+
+	// moderators are not limited
+	if IsUserModerator(data) {
+		return ""
+	}
+
+	if config.MaxOpenQuestionsPerAuthor > 0 && db.GetAuthorOpenQuestionsCount(data.UserId) >= config.MaxOpenQuestionsPerAuthor {
+		return data.Static.Trans("warn_too_many_open_questions", config.MaxOpenQuestionsPerAuthor)
+	}
+
+	now := time.Now()
+	var availableTime int64
+
+	if config.MaxCommitsPerDay > 0 {
+		commitTimes := db.GetAuthorCommitTimes(data.UserId, now.Add(-commitsCountPeriod).Unix())
+		if len(commitTimes) >= config.MaxCommitsPerDay {
+			// the user can post again when the oldest commit in the limit leaves the period
+			limitingCommitTime := commitTimes[len(commitTimes)-config.MaxCommitsPerDay]
+			availableTime = limitingCommitTime + int64(commitsCountPeriod/time.Second)
+		}
+	}
+
+	if config.CommitCooldownMinutes > 0 {
+		commitTimes := db.GetAuthorCommitTimes(data.UserId, now.Add(-time.Duration(config.CommitCooldownMinutes)*time.Minute).Unix())
+		if len(commitTimes) > 0 {
+			cooldownEndTime := commitTimes[len(commitTimes)-1] + int64(config.CommitCooldownMinutes*60)
+			if cooldownEndTime > availableTime {
+				availableTime = cooldownEndTime
+			}
+		}
+	}
+
+	if availableTime > now.Unix() {
+		return data.Static.Trans("warn_question_quota_exceeded", map[string]interface{}{
+			"Time": FormatTime(availableTime, db.GetUserTimezone(data.UserId)),
+		})
+	}
+
+	return ""
+}
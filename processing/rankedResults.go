@@ -0,0 +1,171 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// runoffRound is one round of the instant-runoff count
+type runoffRound struct {
+	votes      map[int]int // votes of the variants that are still in the count
+	eliminated []int
+	exhausted  int // ballots that have no variants left in the count
+}
+
+// calculateInstantRunoff counts the ballots round by round eliminating the variants with the fewest votes
+// until one variant has the majority, winners has several variants if the last ones are tied
+func calculateInstantRunoff(ballots [][]int, variantsCount int) (rounds []runoffRound, winners []int) {
+	active := make(map[int]bool)
+	for i := 0; i < variantsCount; i++ {
+		active[i] = true
+	}
+
+	for len(active) > 0 {
+		round := runoffRound{votes: make(map[int]int)}
+		for variant := range active {
+			round.votes[variant] = 0
+		}
+
+		var total int
+		for _, ballot := range ballots {
+			isCounted := false
+			for _, variant := range ballot {
+				if active[variant] {
+					round.votes[variant]++
+					isCounted = true
+					break
+				}
+			}
+			if isCounted {
+				total++
+			} else {
+				round.exhausted++
+			}
+		}
+
+		if total == 0 {
+			rounds = append(rounds, round)
+			return
+		}
+
+		minVotes := total
+		for variant, votes := range round.votes {
+			if votes*2 > total {
+				rounds = append(rounds, round)
+				return rounds, []int{variant}
+			}
+			if votes < minVotes {
+				minVotes = votes
+			}
+		}
+
+		for variant, votes := range round.votes {
+			if votes == minVotes {
+				round.eliminated = append(round.eliminated, variant)
+			}
+		}
+		sort.Ints(round.eliminated)
+		rounds = append(rounds, round)
+
+		// all the remaining variants have the same votes
+		if len(round.eliminated) == len(active) {
+			return rounds, round.eliminated
+		}
+
+		for _, variant := range round.eliminated {
+			delete(active, variant)
+		}
+	}
+
+	return
+}
+
+// calculateBordaScores gives each variant as many points as the count of the variants ranked below it,
+// the variants that are not ranked in a ballot get no points from it
+func calculateBordaScores(ballots [][]int, variantsCount int) (scores []int) {
+	scores = make([]int, variantsCount)
+	for _, ballot := range ballots {
+		for position, variant := range ballot {
+			if variant >= 0 && variant < variantsCount {
+				scores[variant] += variantsCount - 1 - position
+			}
+		}
+	}
+	return
+}
+
+func getRankedResultsBody(staticData *StaticProccessStructs, questionId int64) string {
+	variants := staticData.Db.GetQuestionVariants(questionId)
+	ballots := staticData.Db.GetQuestionBallots(questionId)
+	rounds, winners := calculateInstantRunoff(ballots, len(variants))
+
+	var buffer bytes.Buffer
+	for i, round := range rounds {
+		// show the variants with the most votes first
+		roundVariants := make([]int, 0, len(round.votes))
+		for variant := range round.votes {
+			roundVariants = append(roundVariants, variant)
+		}
+		sort.Slice(roundVariants, func(a, b int) bool {
+			if round.votes[roundVariants[a]] != round.votes[roundVariants[b]] {
+				return round.votes[roundVariants[a]] > round.votes[roundVariants[b]]
+			}
+			return roundVariants[a] < roundVariants[b]
+		})
+
+		buffer.WriteString("\n" + staticData.Trans("ranked_round", map[string]interface{}{"Number": i + 1}))
+		for _, variant := range roundVariants {
+			buffer.WriteString(fmt.Sprintf("\n%s - %d", variants[variant], round.votes[variant]))
+		}
+		if round.exhausted > 0 {
+			buffer.WriteString("\n" + staticData.Trans("ranked_exhausted", round.exhausted))
+		}
+		// the last round either has the winner or the tie
+		if i < len(rounds)-1 {
+			buffer.WriteString("\n" + staticData.Trans("ranked_eliminated", map[string]interface{}{
+				"Variants": joinVariants(variants, round.eliminated),
+			}))
+		}
+	}
+
+	switch len(winners) {
+	case 0:
+		buffer.WriteString("\n" + staticData.Trans("ranked_no_winner"))
+	case 1:
+		buffer.WriteString("\n" + staticData.Trans("ranked_winner", map[string]interface{}{"Variant": variants[winners[0]]}))
+	default:
+		buffer.WriteString("\n" + staticData.Trans("ranked_tie", map[string]interface{}{"Variants": joinVariants(variants, winners)}))
+	}
+
+	if len(ballots) > 0 {
+		buffer.WriteString("\n" + staticData.Trans("borda_caption"))
+		scores := calculateBordaScores(ballots, len(variants))
+		for i, variant := range variants {
+			buffer.WriteString(fmt.Sprintf("\n%s - %d", variant, scores[i]))
+		}
+	}
+
+	if staticData.Db.IsQuestionPublic(questionId) {
+		votersByVariant := getVotersByVariant(staticData, questionId)
+		if len(votersByVariant) > 0 {
+			buffer.WriteString("\n" + staticData.Trans("ranked_first_choices_caption"))
+			for i, variant := range variants {
+				if voters, ok := votersByVariant[i]; ok {
+					buffer.WriteString(fmt.Sprintf("\n%s: <i>%s</i>", variant, strings.Join(voters, ", ")))
+				}
+			}
+		}
+	}
+
+	return buffer.String()
+}
+
+func joinVariants(variants []string, indexes []int) string {
+	texts := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		texts = append(texts, variants[index])
+	}
+	return strings.Join(texts, ", ")
+}
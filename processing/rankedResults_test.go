@@ -0,0 +1,121 @@
+package processing
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCalculateInstantRunoff(t *testing.T) {
+	assert := require.New(t)
+
+	testCases := []struct {
+		name          string
+		ballots       [][]int
+		variantsCount int
+		rounds        []runoffRound
+		winners       []int
+	}{
+		{
+			name:          "first round majority",
+			ballots:       [][]int{{0, 1}, {0, 2}, {1, 0}},
+			variantsCount: 3,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 2, 1: 1, 2: 0}},
+			},
+			winners: []int{0},
+		},
+		{
+			name:          "multiple rounds",
+			ballots:       [][]int{{0, 2}, {0, 2}, {1, 2}, {1, 0}, {2, 1}},
+			variantsCount: 3,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 2, 1: 2, 2: 1}, eliminated: []int{2}},
+				{votes: map[int]int{0: 2, 1: 3}},
+			},
+			winners: []int{1},
+		},
+		{
+			name:          "tie for last",
+			ballots:       [][]int{{0}, {0}, {0}, {1, 0}, {2, 1}, {3, 1}},
+			variantsCount: 4,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 3, 1: 1, 2: 1, 3: 1}, eliminated: []int{1, 2, 3}},
+				{votes: map[int]int{0: 4}, exhausted: 2},
+			},
+			winners: []int{0},
+		},
+		{
+			name:          "all tied in the first round",
+			ballots:       [][]int{{0, 1}, {1, 0}},
+			variantsCount: 2,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 1, 1: 1}, eliminated: []int{0, 1}},
+			},
+			winners: []int{0, 1},
+		},
+		{
+			name:          "all tied in the final round",
+			ballots:       [][]int{{0}, {0}, {1}, {1}, {2}},
+			variantsCount: 3,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 2, 1: 2, 2: 1}, eliminated: []int{2}},
+				{votes: map[int]int{0: 2, 1: 2}, eliminated: []int{0, 1}, exhausted: 1},
+			},
+			winners: []int{0, 1},
+		},
+		{
+			name:          "partial ballots",
+			ballots:       [][]int{{0}, {0}, {1, 2}, {2}, {2, 1}, {}},
+			variantsCount: 3,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 2, 1: 1, 2: 2}, eliminated: []int{1}, exhausted: 1},
+				{votes: map[int]int{0: 2, 2: 3}, exhausted: 1},
+			},
+			winners: []int{2},
+		},
+		{
+			name:          "only exhausted ballots",
+			ballots:       [][]int{{}, {}},
+			variantsCount: 2,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 0, 1: 0}, exhausted: 2},
+			},
+			winners: nil,
+		},
+		{
+			name:          "no ballots",
+			ballots:       nil,
+			variantsCount: 2,
+			rounds: []runoffRound{
+				{votes: map[int]int{0: 0, 1: 0}},
+			},
+			winners: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		rounds, winners := calculateInstantRunoff(testCase.ballots, testCase.variantsCount)
+		assert.Equal(testCase.rounds, rounds, testCase.name)
+		assert.Equal(testCase.winners, winners, testCase.name)
+	}
+}
+
+func TestCalculateBordaScores(t *testing.T) {
+	assert := require.New(t)
+
+	testCases := []struct {
+		name          string
+		ballots       [][]int
+		variantsCount int
+		scores        []int
+	}{
+		{"full ballots", [][]int{{0, 1, 2}, {1, 0, 2}, {1, 2, 0}}, 3, []int{3, 5, 1}},
+		{"partial ballots", [][]int{{2}, {1, 0}}, 3, []int{1, 2, 2}},
+		{"unknown variants", [][]int{{5, 0}, {-1}}, 3, []int{1, 0, 0}},
+		{"no ballots", nil, 3, []int{0, 0, 0}},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(testCase.scores, calculateBordaScores(testCase.ballots, testCase.variantsCount), testCase.name)
+	}
+}
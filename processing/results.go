@@ -0,0 +1,145 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+func GetResultsText(staticData *StaticProccessStructs, questionId int64) string {
+	return staticData.Trans("results_header") + getResultsBody(staticData, questionId)
+}
+
+// GetCurrentResultsText returns the results of the question that is not closed yet
+func GetCurrentResultsText(staticData *StaticProccessStructs, questionId int64) string {
+	return staticData.Trans("current_results_header") + getResultsBody(staticData, questionId) +
+		"\n" + GetDificientDataForQuestionText(staticData, questionId)
+}
+
+func getResultsBody(staticData *StaticProccessStructs, questionId int64) string {
+	switch staticData.Db.GetQuestionType(questionId) {
+	case database.QuestionTypeScale:
+		return fmt.Sprintf("<i>%s</i>", staticData.Db.GetQuestionText(questionId)) + getScaleResultsBody(staticData, questionId)
+	case database.QuestionTypeRanked:
+		return fmt.Sprintf("<i>%s</i>", staticData.Db.GetQuestionText(questionId)) + getRankedResultsBody(staticData, questionId)
+	}
+
+	variants := staticData.Db.GetQuestionVariants(questionId)
+	answers := staticData.Db.GetQuestionAnswers(questionId)
+	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("<i>%s</i>", staticData.Db.GetQuestionText(questionId)))
+
+	var votersByVariant map[int][]string
+	if staticData.Db.IsQuestionPublic(questionId) {
+		votersByVariant = getVotersByVariant(staticData, questionId)
+	}
+
+	for i, variant := range variants {
+		var percent int64
+		if answersCount > 0 {
+			percent = int64(100.0 * float32(answers[i]) / float32(answersCount))
+		}
+		buffer.WriteString(fmt.Sprintf("\n%s - %d (%d%%)", variant, answers[i], percent))
+		if voters, ok := votersByVariant[i]; ok {
+			buffer.WriteString(fmt.Sprintf("\n<i>%s</i>", strings.Join(voters, ", ")))
+		}
+	}
+	return buffer.String()
+}
+
+// getVotersByVariant returns names of the users that chose each variant of the public question
+func getVotersByVariant(staticData *StaticProccessStructs, questionId int64) map[int][]string {
+	votersByVariant := make(map[int][]string)
+	for _, voter := range staticData.Db.GetQuestionVoters(questionId) {
+		var name string
+		if voter.Username != "" {
+			name = "@" + voter.Username
+		} else if voter.DisplayName != "" {
+			name = SanitizeText(voter.DisplayName)
+		} else {
+			name = staticData.Trans("unknown_voter")
+		}
+		votersByVariant[voter.VariantIndex] = append(votersByVariant[voter.VariantIndex], name)
+	}
+	return votersByVariant
+}
+
+func SendResults(staticData *StaticProccessStructs, questionId int64, chatId int64) {
+	staticData.Chat.SendMessage(chatId, GetResultsText(staticData, questionId))
+}
+
+func CompleteQuestion(staticData *StaticProccessStructs, questionId int64) {
+	if surveyId, ok := staticData.Db.GetQuestionSurvey(questionId); ok {
+		completeSurvey(staticData, surveyId)
+		return
+	}
+
+	RemoveActiveQuestion(staticData, questionId)
+	metrics.CountCompletedQuestion()
+	logrus.WithField("question_id", questionId).Info("Question completed")
+	chatIds := staticData.Db.GetResultsRecipientsChatIds(questionId)
+	staticData.Chat.BroadcastMessage(chatIds, GetResultsText(staticData, questionId))
+}
+
+func IsQuestionReadyToBeCompleted(staticData *StaticProccessStructs, questionId int64) bool {
+	if surveyId, ok := staticData.Db.GetQuestionSurvey(questionId); ok {
+		return isSurveyReadyToBeCompleted(staticData, surveyId)
+	}
+
+	minAnswers, maxAnswers, _ := staticData.Db.GetQuestionRules(questionId)
+
+	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+
+	if answersCount >= maxAnswers && maxAnswers > 0 {
+		return true
+	}
+
+	if _, ok := staticData.Timers[questionId]; !ok {
+		if answersCount >= minAnswers {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ProcessCompleteness(staticData *StaticProccessStructs, questionId int64) (isCompleted bool) {
+	if IsQuestionReadyToBeCompleted(staticData, questionId) {
+		CompleteQuestion(staticData, questionId)
+		return true
+	}
+	return false
+}
+
+func GetDificientDataForQuestionText(staticData *StaticProccessStructs, questionId int64) string {
+	minAnswers, maxAnswers, endTime := staticData.Db.GetQuestionRules(questionId)
+	answersCount := staticData.Db.GetQuestionAnswersCount(questionId)
+
+	// recalculate currently deficient values
+	minAnswers = minAnswers - answersCount
+	maxAnswers = maxAnswers - answersCount
+	var timeHours int64
+	if endTime > 0 {
+		timeHours = int64(time.Unix(endTime, 0).Sub(time.Now()).Hours() + 1)
+	}
+
+	if minAnswers < 0 {
+		minAnswers = 0
+	}
+
+	if maxAnswers < 0 {
+		maxAnswers = 0
+	}
+
+	if timeHours < 0 {
+		timeHours = 0
+	}
+
+	return GetQuestionRulesText(minAnswers, maxAnswers, timeHours, "delta_answers", staticData.Trans)
+}
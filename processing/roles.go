@@ -0,0 +1,13 @@
+package processing
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+)
+
+func IsUserModerator(data *ProcessData) bool {
+	return data.Static.Db.GetUserRole(data.UserId) >= database.RoleModerator
+}
+
+func IsUserAdmin(data *ProcessData) bool {
+	return data.Static.Db.GetUserRole(data.UserId) >= database.RoleAdmin
+}
@@ -0,0 +1,144 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// length of the bar of the most popular value in the histogram
+	histogramWidth = 10
+	// values of the 0-10 scale that count as promoters and detractors for the net promoter score
+	npsMinValue     = 0
+	npsMaxValue     = 10
+	npsMinPromoter  = 9
+	npsMaxDetractor = 6
+	// how the mean, median and standard deviation are printed
+	statsFormat = "%.2f"
+)
+
+type scaleStats struct {
+	mean   float64
+	median float64
+	stdDev float64
+}
+
+// calculateScaleStats calculates the statistics of the votes, counts[i] is the number of votes for values[i],
+// values should be sorted
+func calculateScaleStats(values []int, counts []int) (stats scaleStats, ok bool) {
+	var total int
+	var sum float64
+	for i, value := range values {
+		total += counts[i]
+		sum += float64(value * counts[i])
+	}
+
+	if total == 0 {
+		return
+	}
+
+	stats.mean = sum / float64(total)
+
+	var squaresSum float64
+	for i, value := range values {
+		deviation := float64(value) - stats.mean
+		squaresSum += deviation * deviation * float64(counts[i])
+	}
+	stats.stdDev = math.Sqrt(squaresSum / float64(total))
+
+	// median is the middle vote, or the average of the two middle votes
+	lowerMiddle := (total - 1) / 2
+	upperMiddle := total / 2
+	var passed int
+	lowerFound := false
+	for i, value := range values {
+		passed += counts[i]
+		if !lowerFound && passed > lowerMiddle {
+			stats.median = float64(value)
+			lowerFound = true
+		}
+		if passed > upperMiddle {
+			stats.median = (stats.median + float64(value)) / 2
+			break
+		}
+	}
+
+	return stats, true
+}
+
+// calculateNps returns the percentage of promoters minus the percentage of detractors
+func calculateNps(values []int, counts []int) (nps int, ok bool) {
+	var total, promoters, detractors int
+	for i, value := range values {
+		total += counts[i]
+		if value >= npsMinPromoter {
+			promoters += counts[i]
+		} else if value <= npsMaxDetractor {
+			detractors += counts[i]
+		}
+	}
+
+	if total == 0 {
+		return
+	}
+
+	return int(math.Round(100.0 * float64(promoters-detractors) / float64(total))), true
+}
+
+func isNpsScale(values []int) bool {
+	return len(values) > 0 && values[0] == npsMinValue && values[len(values)-1] == npsMaxValue
+}
+
+func getScaleResultsBody(staticData *StaticProccessStructs, questionId int64) string {
+	values := staticData.Db.GetQuestionVariantValues(questionId)
+	counts := staticData.Db.GetQuestionAnswers(questionId)
+	if len(values) != len(counts) {
+		return ""
+	}
+
+	var maxCount int
+	var total int
+	for _, count := range counts {
+		total += count
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+
+	var votersByVariant map[int][]string
+	if staticData.Db.IsQuestionPublic(questionId) {
+		votersByVariant = getVotersByVariant(staticData, questionId)
+	}
+
+	var buffer bytes.Buffer
+	for i, value := range values {
+		var barLength int
+		var percent int64
+		if maxCount > 0 {
+			barLength = histogramWidth * counts[i] / maxCount
+			percent = int64(100.0 * float32(counts[i]) / float32(total))
+		}
+		buffer.WriteString(fmt.Sprintf("\n%d %s %d (%d%%)", value, strings.Repeat("█", barLength), counts[i], percent))
+		if voters, ok := votersByVariant[i]; ok {
+			buffer.WriteString(fmt.Sprintf("\n<i>%s</i>", strings.Join(voters, ", ")))
+		}
+	}
+
+	if stats, ok := calculateScaleStats(values, counts); ok {
+		buffer.WriteString("\n" + staticData.Trans("scale_stats", map[string]interface{}{
+			"Mean":   fmt.Sprintf(statsFormat, stats.mean),
+			"Median": fmt.Sprintf(statsFormat, stats.median),
+			"StdDev": fmt.Sprintf(statsFormat, stats.stdDev),
+		}))
+
+		if isNpsScale(values) {
+			if nps, ok := calculateNps(values, counts); ok {
+				buffer.WriteString("\n" + staticData.Trans("scale_nps", map[string]interface{}{"Nps": nps}))
+			}
+		}
+	}
+
+	return buffer.String()
+}
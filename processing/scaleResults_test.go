@@ -0,0 +1,74 @@
+package processing
+
+import (
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestCalculateScaleStats(t *testing.T) {
+	assert := require.New(t)
+
+	testCases := []struct {
+		name   string
+		values []int
+		counts []int
+		stats  scaleStats
+		ok     bool
+	}{
+		{"odd", []int{1, 2, 3, 4, 5}, []int{1, 1, 1, 1, 1}, scaleStats{mean: 3, median: 3, stdDev: math.Sqrt(2)}, true},
+		{"even", []int{1, 2, 3, 4}, []int{1, 1, 1, 1}, scaleStats{mean: 2.5, median: 2.5, stdDev: math.Sqrt(1.25)}, true},
+		{"even same middle", []int{1, 2, 3}, []int{1, 2, 1}, scaleStats{mean: 2, median: 2, stdDev: math.Sqrt(0.5)}, true},
+		{"even skipped values", []int{1, 2, 3, 4, 5}, []int{2, 0, 0, 0, 2}, scaleStats{mean: 3, median: 3, stdDev: 2}, true},
+		{"odd weighted", []int{1, 2, 3, 4, 5}, []int{0, 1, 0, 0, 2}, scaleStats{mean: 4, median: 5, stdDev: math.Sqrt(2)}, true},
+		{"single vote", []int{1, 7}, []int{0, 1}, scaleStats{mean: 7, median: 7, stdDev: 0}, true},
+		{"no votes", []int{1, 2, 3}, []int{0, 0, 0}, scaleStats{}, false},
+		{"no values", []int{}, []int{}, scaleStats{}, false},
+	}
+
+	for _, testCase := range testCases {
+		stats, ok := calculateScaleStats(testCase.values, testCase.counts)
+		assert.Equal(testCase.ok, ok, testCase.name)
+		assert.InDelta(testCase.stats.mean, stats.mean, 1e-9, testCase.name)
+		assert.InDelta(testCase.stats.median, stats.median, 1e-9, testCase.name)
+		assert.InDelta(testCase.stats.stdDev, stats.stdDev, 1e-9, testCase.name)
+	}
+}
+
+func TestCalculateNps(t *testing.T) {
+	assert := require.New(t)
+
+	values := []int{0, 6, 7, 8, 9, 10}
+
+	testCases := []struct {
+		name   string
+		counts []int
+		nps    int
+		ok     bool
+	}{
+		{"only promoters", []int{0, 0, 0, 0, 1, 1}, 100, true},
+		{"only detractors", []int{1, 1, 0, 0, 0, 0}, -100, true},
+		{"only passives", []int{0, 0, 1, 1, 0, 0}, 0, true},
+		{"rounded down", []int{0, 0, 1, 1, 0, 1}, 33, true},
+		{"rounded up", []int{0, 0, 1, 0, 1, 1}, 67, true},
+		{"negative rounded", []int{1, 1, 0, 0, 0, 1}, -33, true},
+		{"half rounded away from zero", []int{0, 0, 4, 3, 1, 0}, 13, true},
+		{"negative half rounded away from zero", []int{0, 1, 4, 3, 0, 0}, -13, true},
+		{"no votes", []int{0, 0, 0, 0, 0, 0}, 0, false},
+	}
+
+	for _, testCase := range testCases {
+		nps, ok := calculateNps(values, testCase.counts)
+		assert.Equal(testCase.ok, ok, testCase.name)
+		assert.Equal(testCase.nps, nps, testCase.name)
+	}
+}
+
+func TestIsNpsScale(t *testing.T) {
+	assert := require.New(t)
+
+	assert.True(isNpsScale([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+	assert.False(isNpsScale([]int{1, 2, 3, 4, 5}))
+	assert.False(isNpsScale([]int{0, 1, 2, 3, 4, 5}))
+	assert.False(isNpsScale([]int{}))
+}
@@ -14,19 +14,42 @@ const (
 	WaitingText
 	WaitingVariants
 	WaitingRules
+	WaitingPublishedText
+	WaitingPublishedVariants
+	WaitingScale
 )
 
 type StaticConfiguration struct {
-	Language    string
-	Moderators  []int64
-	ExtendedLog bool
+	Language       string
+	Moderators     []int64 // chat ids of the users that are always admins and can't be revoked, other roles are stored in the database
+	ExtendedLog    bool    // log the requests to Telegram, ignored in privacy mode
+	MetricsAddress string
+	LogLevel       string // "debug", "info", "warning", "error"
+	LogFormat      string // "json" or "logfmt"
+	LogFile        string // empty to log to stderr
+	PrivacyMode    bool   // don't write texts of messages to the log
+	QuestionsOrder string // "oldest", "deadline" or "fewest_answers"
+	// limits for question authors, zero means no limit
+	MaxOpenQuestionsPerAuthor int
+	MaxCommitsPerDay          int
+	CommitCooldownMinutes     int
+	// content policy, zero means no limit
+	MaxQuestionTextLength int
+	MaxVariantLength      int
+	MaxVariantsCount      int
+	BlockUrls             bool
+	StopWords             []string
+	// count of reports from different users after which the question is hidden, zero to never hide
+	ReportsToSuspend int
 }
 
 type StaticProccessStructs struct {
-	Chat       chat.Chat
-	Db         *database.Database
-	UserStates map[int64]UserState
-	Timers     map[int64]time.Time
-	Config     *StaticConfiguration
-	Trans      i18n.TranslateFunc
+	Chat            chat.Chat
+	Db              *database.Database
+	UserStates      map[int64]UserState
+	Timers          map[int64]time.Time
+	Config          *StaticConfiguration
+	Trans           i18n.TranslateFunc
+	ContentPolicy   ContentPolicy
+	EditedQuestions map[int64]int64 // chat id to the id of the open question that the author is editing
 }
@@ -0,0 +1,243 @@
+package processing
+
+import (
+	"bytes"
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const maxSurveyQuestions = 20
+
+func IsQuestionInSurvey(staticData *StaticProccessStructs, questionId int64) bool {
+	_, ok := staticData.Db.GetQuestionSurvey(questionId)
+	return ok
+}
+
+// AddQuestionToSurvey keeps the edited question in its survey (creating the survey for the first question)
+// and starts editing the next question of the survey with the same rules and voting settings
+func AddQuestionToSurvey(data *ProcessData, questionId int64) (ok bool) {
+	db := data.Static.Db
+
+	surveyId, isInSurvey := db.GetQuestionSurvey(questionId)
+	if !isInSurvey {
+		surveyId = db.CreateSurvey(questionId)
+	}
+
+	questionsCount := len(db.GetSurveyQuestions(surveyId))
+	if questionsCount >= maxSurveyQuestions {
+		return false
+	}
+
+	hasRules := db.IsQuestionHasRules(questionId)
+	minAnswers, maxAnswers, hours := db.GetQuestionRules(questionId)
+	isPublic := db.IsQuestionPublic(questionId)
+	resultsVisibility := db.GetQuestionResultsVisibility(questionId)
+
+	db.ParkSurveyQuestion(questionId)
+	db.StartCreatingQuestion(data.UserId)
+
+	nextQuestionId := db.GetUserEditingQuestion(data.UserId)
+	db.SetQuestionSurvey(nextQuestionId, surveyId, questionsCount)
+	if hasRules {
+		db.SetQuestionRules(nextQuestionId, minAnswers, maxAnswers, hours)
+	}
+	db.SetQuestionPublic(nextQuestionId, isPublic)
+	db.SetQuestionResultsVisibility(nextQuestionId, resultsVisibility)
+
+	data.Log.WithFields(logrus.Fields{
+		"survey_id":   surveyId,
+		"question_id": questionId,
+	}).Info("Question added to survey")
+	return true
+}
+
+// DiscardEditedQuestion removes the question that is being edited, with the whole survey if it's a part of one
+func DiscardEditedQuestion(staticData *StaticProccessStructs, questionId int64) {
+	if surveyId, ok := staticData.Db.GetQuestionSurvey(questionId); ok {
+		staticData.Db.DiscardSurvey(surveyId)
+	} else {
+		staticData.Db.DiscardQuestion(questionId)
+	}
+}
+
+// commitSurvey publishes all the questions of the survey with the rules of the last one,
+// the users receive them one by one starting from the first
+func commitSurvey(data *ProcessData, surveyId int64, lastQuestionId int64) {
+	db := data.Static.Db
+	minAnswers, maxAnswers, durationTime := db.GetQuestionRules(lastQuestionId)
+	endTime := time.Now().Add(time.Duration(durationTime) * time.Hour)
+
+	questions := db.GetSurveyQuestions(surveyId)
+	for _, questionId := range questions {
+		db.CommitQuestion(questionId)
+		db.SetQuestionRules(questionId, minAnswers, maxAnswers, endTime.Unix())
+		data.Static.Timers[questionId] = endTime
+		metrics.CountCommittedQuestion()
+	}
+	db.SetSurveyStatus(surveyId, database.SurveyStatusOpen)
+
+	data.Log.WithField("survey_id", surveyId).Info("Survey committed")
+	data.Static.Chat.SendMessage(data.ChatId, data.Static.Trans("say_survey_commited", len(questions)))
+
+	ProcessNextQuestion(data)
+
+	users := db.GetReadyUsersChatIds()
+	data.Static.Chat.BroadcastQuestion(db, questions[0], users)
+}
+
+// isSurveyReadyToBeCompleted checks the shared rules against the users that went through the whole survey
+func isSurveyReadyToBeCompleted(staticData *StaticProccessStructs, surveyId int64) bool {
+	if staticData.Db.GetSurveyStatus(surveyId) != database.SurveyStatusOpen {
+		return false
+	}
+
+	questions := staticData.Db.GetSurveyQuestions(surveyId)
+	if len(questions) == 0 {
+		return false
+	}
+
+	minAnswers, maxAnswers, _ := staticData.Db.GetQuestionRules(questions[0])
+	finishedCount, _ := staticData.Db.GetSurveyRespondentsCounts(surveyId)
+
+	if finishedCount >= maxAnswers && maxAnswers > 0 {
+		return true
+	}
+
+	// all the questions share the deadline, the timers that fired are removed one by one
+	now := time.Now()
+	for _, questionId := range questions {
+		if endTime, ok := staticData.Timers[questionId]; ok && endTime.After(now) {
+			return false
+		}
+	}
+
+	return finishedCount >= minAnswers
+}
+
+// withdrawSurveyFromUsers removes all the questions of the survey from the users
+// and sends the next questions to the users that were answering it
+func withdrawSurveyFromUsers(staticData *StaticProccessStructs, questions []int64) {
+	var users []int64
+	for _, questionId := range questions {
+		delete(staticData.Timers, questionId)
+		users = append(users, staticData.Db.GetUsersAnsweringQuestionNow(questionId)...)
+	}
+
+	for _, questionId := range questions {
+		staticData.Db.RemoveQuestionFromAllUsers(questionId)
+	}
+
+	for _, user := range users {
+		chatId := staticData.Db.GetUserChatId(user)
+		staticData.Chat.BroadcastMessage([]int64{chatId}, staticData.Trans("say_question_outdated"))
+
+		if staticData.Db.IsUserHasPendingQuestions(user) {
+			staticData.Chat.BroadcastQuestion(staticData.Db, staticData.Db.GetUserNextQuestion(user), []int64{chatId})
+		} else {
+			staticData.Db.MarkUserReady(user)
+		}
+	}
+}
+
+func getSurveyResultsText(staticData *StaticProccessStructs, surveyId int64, questions []int64) string {
+	finishedCount, inProgressCount := staticData.Db.GetSurveyRespondentsCounts(surveyId)
+
+	var buffer bytes.Buffer
+	buffer.WriteString(staticData.Trans("survey_results_header"))
+	buffer.WriteString(staticData.Trans("survey_respondents", map[string]interface{}{
+		"Finished":   finishedCount,
+		"InProgress": inProgressCount,
+	}))
+
+	for i, questionId := range questions {
+		buffer.WriteString("\n\n" + staticData.Trans("survey_progress", map[string]interface{}{
+			"Position": i + 1,
+			"Count":    len(questions),
+		}) + "\n")
+		buffer.WriteString(getResultsBody(staticData, questionId))
+	}
+
+	return buffer.String()
+}
+
+// completeSurvey closes all the questions of the survey and sends one combined report
+func completeSurvey(staticData *StaticProccessStructs, surveyId int64) {
+	if staticData.Db.GetSurveyStatus(surveyId) != database.SurveyStatusOpen {
+		return
+	}
+
+	questions := staticData.Db.GetSurveyQuestions(surveyId)
+	// the respondents are counted before their pending questions are removed
+	resultsText := getSurveyResultsText(staticData, surveyId, questions)
+
+	staticData.Db.SetSurveyStatus(surveyId, database.SurveyStatusClosed)
+	// the questions are finished before the users that were answering them get their next questions
+	for _, questionId := range questions {
+		staticData.Db.FinishQuestion(questionId)
+	}
+	withdrawSurveyFromUsers(staticData, questions)
+
+	var chatIds []int64
+	isAdded := make(map[int64]bool)
+	for _, questionId := range questions {
+		metrics.CountCompletedQuestion()
+		for _, chatId := range staticData.Db.GetResultsRecipientsChatIds(questionId) {
+			if !isAdded[chatId] {
+				isAdded[chatId] = true
+				chatIds = append(chatIds, chatId)
+			}
+		}
+	}
+
+	logrus.WithField("survey_id", surveyId).Info("Survey completed")
+	staticData.Chat.BroadcastMessage(chatIds, resultsText)
+}
+
+// suspendSurvey hides all the questions of the survey from the users until a moderator reviews it
+func suspendSurvey(staticData *StaticProccessStructs, surveyId int64) {
+	if staticData.Db.GetSurveyStatus(surveyId) != database.SurveyStatusOpen {
+		return
+	}
+
+	questions := staticData.Db.GetSurveyQuestions(surveyId)
+	withdrawSurveyFromUsers(staticData, questions)
+	for _, questionId := range questions {
+		staticData.Db.SuspendQuestion(questionId)
+	}
+	// the survey is not completed while it is suspended
+	staticData.Db.SetSurveyStatus(surveyId, database.SurveyStatusSuspended)
+
+	logrus.WithField("survey_id", surveyId).Info("Survey suspended")
+}
+
+// RestoreSurvey gives the suspended survey to the users again, they continue from the first question
+// they haven't answered
+func RestoreSurvey(staticData *StaticProccessStructs, surveyId int64) {
+	if staticData.Db.GetSurveyStatus(surveyId) != database.SurveyStatusSuspended {
+		return
+	}
+
+	questions := staticData.Db.GetSurveyQuestions(surveyId)
+	for _, questionId := range questions {
+		restoreSuspendedQuestion(staticData, questionId)
+	}
+	staticData.Db.SetSurveyStatus(surveyId, database.SurveyStatusOpen)
+
+	logrus.WithField("survey_id", surveyId).Info("Survey restored")
+
+	isPending := make(map[int64]bool)
+	for _, questionId := range questions {
+		for _, chatId := range staticData.Db.GetQuestionPendingChatIds(questionId) {
+			isPending[chatId] = true
+		}
+	}
+
+	for _, chatId := range staticData.Db.GetReadyUsersChatIds() {
+		if isPending[chatId] {
+			nextQuestion := staticData.Db.GetUserNextQuestion(staticData.Db.GetUserId(chatId))
+			staticData.Chat.BroadcastQuestion(staticData.Db, nextQuestion, []int64{chatId})
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package processing
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func makeTestProcessData(staticData *StaticProccessStructs, chatId int64) *ProcessData {
+	return &ProcessData{
+		Static: staticData,
+		ChatId: chatId,
+		UserId: staticData.Db.GetUserId(chatId),
+		Log:    logrus.WithField("chat_id", chatId),
+	}
+}
+
+// commitTestSurvey publishes a survey of two questions that is completed after two respondents
+func commitTestSurvey(assert *require.Assertions, author *ProcessData) (surveyId int64, questionId1 int64, questionId2 int64) {
+	db := author.Static.Db
+
+	db.StartCreatingQuestion(author.UserId)
+	questionId1 = db.GetUserEditingQuestion(author.UserId)
+	db.SetQuestionText(questionId1, "first")
+	db.SetQuestionVariants(questionId1, []string{"a", "b"})
+	db.SetQuestionRules(questionId1, 1, 2, 24)
+	assert.True(AddQuestionToSurvey(author, questionId1))
+
+	// the next question is edited with the same rules
+	questionId2 = db.GetUserEditingQuestion(author.UserId)
+	assert.NotEqual(questionId1, questionId2)
+	db.SetQuestionText(questionId2, "second")
+	db.SetQuestionVariants(questionId2, []string{"c", "d"})
+
+	surveyId, ok := db.GetQuestionSurvey(questionId2)
+	assert.True(ok)
+
+	CommitQuestion(author, questionId2)
+	return
+}
+
+// answerTestQuestion records the answer the same way as it's done for the user's messages
+func answerTestQuestion(data *ProcessData, questionId int64) {
+	data.Static.Db.AddQuestionAnswer(questionId, data.UserId, 0)
+	data.Static.Db.RemoveUserPendingQuestion(data.UserId, questionId)
+	ProcessCompleteness(data.Static, questionId)
+	ProcessNextQuestion(data)
+}
+
+func TestSurveyCompletion(t *testing.T) {
+	assert := require.New(t)
+	db := connectTestDb(t)
+	if db == nil {
+		return
+	}
+	defer disconnectTestDb(db)
+	staticData := makeTestStaticData(db)
+	chat := staticData.Chat.(*testChat)
+
+	author := makeTestProcessData(staticData, 121)
+	user1 := makeTestProcessData(staticData, 122)
+	user2 := makeTestProcessData(staticData, 123)
+
+	surveyId, questionId1, questionId2 := commitTestSurvey(assert, author)
+
+	assert.Equal(database.SurveyStatusOpen, db.GetSurveyStatus(surveyId))
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId1))
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId2))
+	// all the questions share the rules and the deadline of the last one
+	{
+		minAnswers1, maxAnswers1, endTime1 := db.GetQuestionRules(questionId1)
+		minAnswers2, maxAnswers2, endTime2 := db.GetQuestionRules(questionId2)
+		assert.Equal(minAnswers1, minAnswers2)
+		assert.Equal(2, maxAnswers1)
+		assert.Equal(maxAnswers1, maxAnswers2)
+		assert.Equal(endTime1, endTime2)
+		assert.Equal(endTime1, staticData.Timers[questionId1].Unix())
+		assert.Equal(endTime2, staticData.Timers[questionId2].Unix())
+	}
+
+	// the users receive only the first question
+	assert.ElementsMatch([]int64{121, 122, 123}, chat.questions[questionId1])
+	assert.Equal(0, len(chat.questions[questionId2]))
+	assert.Equal(questionId1, db.GetUserNextQuestion(user1.UserId))
+
+	// and get the next one after answering it
+	answerTestQuestion(user1, questionId1)
+	assert.Equal([]int64{122}, chat.questions[questionId2])
+	assert.Equal(questionId2, db.GetUserNextQuestion(user1.UserId))
+	assert.Equal(questionId1, db.GetUserNextQuestion(user2.UserId))
+
+	answerTestQuestion(user1, questionId2)
+	assert.Equal(database.SurveyStatusOpen, db.GetSurveyStatus(surveyId))
+	{
+		finished, inProgress := db.GetSurveyRespondentsCounts(surveyId)
+		assert.Equal(1, finished)
+		assert.Equal(0, inProgress)
+	}
+
+	answerTestQuestion(user2, questionId1)
+	assert.Equal(database.SurveyStatusOpen, db.GetSurveyStatus(surveyId))
+
+	// the second user that finished the survey reaches the limit
+	answerTestQuestion(user2, questionId2)
+	assert.Equal(database.SurveyStatusClosed, db.GetSurveyStatus(surveyId))
+	assert.Equal(database.QuestionStatusClosed, db.GetQuestionStatus(questionId1))
+	assert.Equal(database.QuestionStatusClosed, db.GetQuestionStatus(questionId2))
+	assert.False(db.IsQuestionPendingForUser(author.UserId, questionId1))
+	assert.False(db.IsQuestionPendingForUser(author.UserId, questionId2))
+	assert.Equal(0, len(staticData.Timers))
+	assert.True(strings.HasPrefix(chat.messages[len(chat.messages)-1], "survey_results_header"))
+}
+
+func TestSurveySuspension(t *testing.T) {
+	assert := require.New(t)
+	db := connectTestDb(t)
+	if db == nil {
+		return
+	}
+	defer disconnectTestDb(db)
+	staticData := makeTestStaticData(db)
+	chat := staticData.Chat.(*testChat)
+
+	author := makeTestProcessData(staticData, 121)
+	user1 := makeTestProcessData(staticData, 122)
+	user2 := makeTestProcessData(staticData, 123)
+
+	surveyId, questionId1, questionId2 := commitTestSurvey(assert, author)
+	answerTestQuestion(user1, questionId1)
+
+	// suspending one question hides the whole survey
+	SuspendQuestion(staticData, questionId2)
+	assert.Equal(database.SurveyStatusSuspended, db.GetSurveyStatus(surveyId))
+	assert.Equal(database.QuestionStatusSuspended, db.GetQuestionStatus(questionId1))
+	assert.Equal(database.QuestionStatusSuspended, db.GetQuestionStatus(questionId2))
+	assert.False(db.IsUserHasPendingQuestions(user1.UserId))
+	assert.False(db.IsUserHasPendingQuestions(user2.UserId))
+	assert.Equal(0, len(staticData.Timers))
+
+	// the suspended survey is not completed
+	assert.False(ProcessCompleteness(staticData, questionId1))
+	assert.Equal(database.SurveyStatusSuspended, db.GetSurveyStatus(surveyId))
+
+	chat.questions = make(map[int64][]int64)
+	RestoreQuestion(staticData, questionId2)
+	assert.Equal(database.SurveyStatusOpen, db.GetSurveyStatus(surveyId))
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId1))
+	assert.Equal(database.QuestionStatusOpen, db.GetQuestionStatus(questionId2))
+	assert.Equal(2, len(staticData.Timers))
+
+	// the users continue from the first question they haven't answered
+	assert.False(db.IsQuestionPendingForUser(user1.UserId, questionId1))
+	assert.True(db.IsQuestionPendingForUser(user1.UserId, questionId2))
+	assert.True(db.IsQuestionPendingForUser(user2.UserId, questionId1))
+	assert.True(db.IsQuestionPendingForUser(user2.UserId, questionId2))
+	assert.Equal([]int64{122}, chat.questions[questionId2])
+	assert.ElementsMatch([]int64{121, 123}, chat.questions[questionId1])
+}
@@ -0,0 +1,99 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"strconv"
+	"strings"
+)
+
+// builtInTemplate is a template that is always available, its texts are taken from the translations
+type builtInTemplate struct {
+	nameId     string
+	variantsId string // translation with one variant per line, empty for the scales
+	scaleMin   int
+	scaleMax   int
+	minAnswers int
+	maxAnswers int
+	hours      int64
+}
+
+var builtInTemplates = []builtInTemplate{
+	{nameId: "template_yes_no", variantsId: "template_yes_no_variants", minAnswers: 5, maxAnswers: 50, hours: 24},
+	{nameId: "template_agreement", variantsId: "template_agreement_variants", minAnswers: 10, maxAnswers: 100, hours: 48},
+	{nameId: "template_rating", scaleMin: 1, scaleMax: 10, minAnswers: 10, maxAnswers: 100, hours: 48},
+	{nameId: "template_nps", scaleMin: 0, scaleMax: 10, minAnswers: 10, maxAnswers: 100, hours: 48},
+	{nameId: "template_reactions", variantsId: "template_reactions_variants", minAnswers: 5, maxAnswers: 50, hours: 24},
+}
+
+func getBuiltInTemplate(staticData *StaticProccessStructs, index int) database.QuestionTemplate {
+	template := builtInTemplates[index]
+	var variants []string
+	if template.variantsId != "" {
+		for _, line := range strings.Split(staticData.Trans(template.variantsId), "\n") {
+			if variant := SanitizeText(line); variant != "" {
+				variants = append(variants, variant)
+			}
+		}
+	} else {
+		for value := template.scaleMin; value <= template.scaleMax; value++ {
+			variants = append(variants, strconv.Itoa(value))
+		}
+	}
+
+	return database.QuestionTemplate{
+		Name:       staticData.Trans(template.nameId),
+		Variants:   variants,
+		ScaleMin:   template.scaleMin,
+		ScaleMax:   template.scaleMax,
+		MinAnswers: template.minAnswers,
+		MaxAnswers: template.maxAnswers,
+		Hours:      template.hours,
+	}
+}
+
+// GetTemplatesListText returns the list of the templates with the commands to apply them,
+// built-in templates are "/tplb<number>", templates from the database are "/tpl<id>"
+func GetTemplatesListText(staticData *StaticProccessStructs) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(staticData.Trans("templates_header"))
+
+	for i := range builtInTemplates {
+		template := getBuiltInTemplate(staticData, i)
+		buffer.WriteString(fmt.Sprintf("\n/tplb%d - %s (%s)", i+1, template.Name, strings.Join(template.Variants, ", ")))
+	}
+
+	for _, template := range staticData.Db.GetQuestionTemplates(staticData.Config.Language) {
+		buffer.WriteString(fmt.Sprintf("\n/tpl%d - %s (%s)", template.Id, template.Name, strings.Join(template.Variants, ", ")))
+	}
+
+	return buffer.String()
+}
+
+// FindTemplate finds the template by the command argument, e.g. "b1" or "12"
+func FindTemplate(staticData *StaticProccessStructs, templateId string) (template database.QuestionTemplate, ok bool) {
+	if strings.HasPrefix(templateId, "b") {
+		index, err := strconv.Atoi(templateId[1:])
+		if err != nil || index < 1 || index > len(builtInTemplates) {
+			return
+		}
+		return getBuiltInTemplate(staticData, index-1), true
+	}
+
+	id, err := strconv.ParseInt(templateId, 10, 64)
+	if err != nil {
+		return
+	}
+	return staticData.Db.GetQuestionTemplate(id)
+}
+
+// ApplyTemplate sets the variants and the suggested rules of the template to the question
+func ApplyTemplate(staticData *StaticProccessStructs, questionId int64, template database.QuestionTemplate) {
+	if template.ScaleMax > template.ScaleMin {
+		staticData.Db.SetQuestionScale(questionId, template.ScaleMin, template.ScaleMax)
+	} else {
+		staticData.Db.SetQuestionVariants(questionId, template.Variants)
+	}
+	staticData.Db.SetQuestionRules(questionId, template.MinAnswers, template.MaxAnswers, template.Hours)
+}
@@ -0,0 +1,86 @@
+package processing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longer durations are rejected so they can't overflow
+const maxParsedDuration = 10 * 365 * 24 * time.Hour
+
+// ParseDuration parses durations like "30m", "12h" or "7d", a number without a suffix means hours
+func ParseDuration(text string) (duration time.Duration, ok bool) {
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return
+	}
+
+	unit := time.Hour
+	switch text[len(text)-1] {
+	case 'm':
+		unit = time.Minute
+		text = text[:len(text)-1]
+	case 'h':
+		text = text[:len(text)-1]
+	case 'd':
+		unit = 24 * time.Hour
+		text = text[:len(text)-1]
+	}
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || value <= 0 || value > int64(maxParsedDuration/unit) {
+		return
+	}
+
+	return time.Duration(value) * unit, true
+}
+
+// ParseDayTime parses time of day in format "hh:mm" and returns minutes from midnight
+func ParseDayTime(text string) (minutes int, ok bool) {
+	parts := strings.Split(strings.TrimSpace(text), ":")
+	if len(parts) != 2 {
+		return
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 23 {
+		return
+	}
+
+	minutesPart, err := strconv.Atoi(parts[1])
+	if err != nil || minutesPart < 0 || minutesPart > 59 {
+		return
+	}
+
+	return hours*60 + minutesPart, true
+}
+
+func FormatDayTime(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// GetLocation returns location for the timezone name, UTC is used if the name is empty or wrong
+func GetLocation(timezone string) *time.Location {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// GetNextDayTime returns the nearest moment after now when it will be the given time of day in the timezone
+func GetNextDayTime(minutes int, timezone string, now time.Time) time.Time {
+	localNow := now.In(GetLocation(timezone))
+	nextTime := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), minutes/60, minutes%60, 0, 0, localNow.Location())
+	if !nextTime.After(now) {
+		nextTime = nextTime.AddDate(0, 0, 1)
+	}
+	return nextTime
+}
+
+// FormatTime formats the timestamp in the given timezone
+func FormatTime(timestamp int64, timezone string) string {
+	return time.Unix(timestamp, 0).In(GetLocation(timezone)).Format("2006-01-02 15:04 MST")
+}
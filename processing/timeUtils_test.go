@@ -0,0 +1,35 @@
+package processing
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	assert := require.New(t)
+
+	testCases := []struct {
+		text     string
+		duration time.Duration
+		ok       bool
+	}{
+		{"30m", 30 * time.Minute, true},
+		{"12h", 12 * time.Hour, true},
+		{"12", 12 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"3650d", maxParsedDuration, true},
+		{"3651d", 0, false},
+		{"99999999999d", 0, false},
+		{"0h", 0, false},
+		{"-5h", 0, false},
+		{"d", 0, false},
+		{"", 0, false},
+	}
+
+	for _, testCase := range testCases {
+		duration, ok := ParseDuration(testCase.text)
+		assert.Equal(testCase.ok, ok, testCase.text)
+		assert.Equal(testCase.duration, duration, testCase.text)
+	}
+}
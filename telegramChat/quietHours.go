@@ -0,0 +1,36 @@
+package telegramChat
+
+import (
+	"github.com/gameraccoon/telegram-poll-bot/database"
+	"time"
+)
+
+// getDeliveryTime returns the time when a message can be delivered to a user
+// with the given quiet hours, messages are deferred until the end of the quiet hours
+func getDeliveryTime(quietHours database.QuietHours, now time.Time) time.Time {
+	location, err := time.LoadLocation(quietHours.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	localNow := now.In(location)
+	minutes := localNow.Hour()*60 + localNow.Minute()
+
+	var isQuiet bool
+	if quietHours.Start <= quietHours.End {
+		isQuiet = minutes >= quietHours.Start && minutes < quietHours.End
+	} else {
+		// the quiet hours continue after midnight
+		isQuiet = minutes >= quietHours.Start || minutes < quietHours.End
+	}
+
+	if !isQuiet {
+		return now
+	}
+
+	endTime := time.Date(localNow.Year(), localNow.Month(), localNow.Day(), quietHours.End/60, quietHours.End%60, 0, 0, location)
+	if minutes >= quietHours.End {
+		endTime = endTime.AddDate(0, 0, 1)
+	}
+	return endTime
+}
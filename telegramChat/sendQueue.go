@@ -0,0 +1,137 @@
+package telegramChat
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Telegram allows about 30 messages per second in total
+	globalSendInterval = time.Second / 30
+	// and not more than one message per second to the same chat
+	perChatSendInterval = time.Second
+	// how long to sleep when there is nothing to send
+	idleWaitTime = time.Minute
+	// clean the last sending times when there are too many of them
+	maxTrackedChats = 1000
+)
+
+type messagePriority int
+
+const (
+	// replies to users that are interacting with the bot right now
+	interactivePriority messagePriority = iota
+	// messages that are sent to many users at once
+	broadcastPriority
+	prioritiesCount
+)
+
+type outgoingMessage struct {
+	id       int64 // id of the message in the outbox
+	chatId   int64
+	text     string
+	priority messagePriority
+	attempts int
+	// id of the message in the chat that should be replaced, zero to send a new message
+	editMessageId int64
+	// if set, the text is sent as a file with this name
+	documentName string
+}
+
+// sendQueue keeps the outbox messages that are loaded to be sent
+type sendQueue struct {
+	mutex       sync.Mutex
+	messages    [prioritiesCount][]outgoingMessage
+	queuedIds   map[int64]bool
+	lastSent    map[int64]time.Time
+	pausedUntil time.Time
+	// signals that new messages were added to the outbox
+	wakeUp chan bool
+}
+
+func makeSendQueue() *sendQueue {
+	return &sendQueue{
+		queuedIds: make(map[int64]bool),
+		lastSent:  make(map[int64]time.Time),
+		wakeUp:    make(chan bool, 1),
+	}
+}
+
+// push adds a message to the end of its queue if it isn't queued yet
+func (queue *sendQueue) push(message outgoingMessage) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.queuedIds[message.id] {
+		return
+	}
+
+	queue.messages[message.priority] = append(queue.messages[message.priority], message)
+	queue.queuedIds[message.id] = true
+}
+
+// pushFront returns a message that wasn't delivered back to the head of its queue
+func (queue *sendQueue) pushFront(message outgoingMessage) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.messages[message.priority] = append([]outgoingMessage{message}, queue.messages[message.priority]...)
+	queue.queuedIds[message.id] = true
+	delete(queue.lastSent, message.chatId)
+}
+
+// pause stops sending any messages until the given time
+func (queue *sendQueue) pause(until time.Time) {
+	queue.mutex.Lock()
+	if until.After(queue.pausedUntil) {
+		queue.pausedUntil = until
+	}
+	queue.mutex.Unlock()
+}
+
+func (queue *sendQueue) notify() {
+	select {
+	case queue.wakeUp <- true:
+	default:
+	}
+}
+
+// pop takes the first message that can be sent right now respecting the priorities
+// and the per-chat limits, or returns the time to wait for the next message
+func (queue *sendQueue) pop(now time.Time) (message outgoingMessage, waitTime time.Duration, isFound bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if now.Before(queue.pausedUntil) {
+		waitTime = queue.pausedUntil.Sub(now)
+		return
+	}
+
+	if len(queue.lastSent) > maxTrackedChats {
+		for chatId, sendTime := range queue.lastSent {
+			if now.Sub(sendTime) >= perChatSendInterval {
+				delete(queue.lastSent, chatId)
+			}
+		}
+	}
+
+	waitTime = idleWaitTime
+	for priority := range queue.messages {
+		messages := queue.messages[priority]
+		for i, candidate := range messages {
+			chatWaitTime := perChatSendInterval - now.Sub(queue.lastSent[candidate.chatId])
+			if chatWaitTime <= 0 {
+				queue.messages[priority] = append(messages[:i], messages[i+1:]...)
+				delete(queue.queuedIds, candidate.id)
+				queue.lastSent[candidate.chatId] = now
+				return candidate, 0, true
+			}
+
+			if chatWaitTime < waitTime {
+				waitTime = chatWaitTime
+			}
+		}
+	}
+
+	return
+}
@@ -5,14 +5,40 @@ import (
 	"fmt"
 	"github.com/gameraccoon/telegram-poll-bot/database"
 	"github.com/gameraccoon/telegram-poll-bot/dialog"
+	"github.com/gameraccoon/telegram-poll-bot/metrics"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/nicksnyder/go-i18n/i18n"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// how often the outbox is checked for the messages that are due
+	outboxPollInterval = 5 * time.Second
+	// maximum count of messages loaded from the outbox at once
+	outboxLoadLimit = 500
+	// the delay after the first failed attempt, doubled after each next one
+	firstRetryDelay     = 10 * time.Second
+	maxDeliveryAttempts = 8
+	// how long the delivered messages are kept in the outbox
+	deliveredMessagesLifetime = 7 * 24 * time.Hour
+	// how often the old delivered messages are removed from the outbox
+	outboxCleanupInterval = time.Hour
+	// how many blocked chats can wait to be processed before the outbox stops
+	blockedChatsBufferSize = 100
 )
 
 type TelegramChat struct {
-	bot *tgbotapi.BotAPI
+	bot   *tgbotapi.BotAPI
+	db    *database.Database
+	trans i18n.TranslateFunc
+	queue *sendQueue
+	// the chats that blocked the bot, the users are changed in the goroutine that processes updates
+	blockedChats chan int64
 }
 
-func MakeTelegramChat(apiToken string) (bot *TelegramChat, outErr error) {
+func MakeTelegramChat(apiToken string, db *database.Database, trans i18n.TranslateFunc) (bot *TelegramChat, outErr error) {
 	newBot, err := tgbotapi.NewBotAPI(apiToken)
 	if err != nil {
 		outErr = err
@@ -20,9 +46,16 @@ func MakeTelegramChat(apiToken string) (bot *TelegramChat, outErr error) {
 	}
 
 	bot = &TelegramChat{
-		bot: newBot,
+		bot:   newBot,
+		db:    db,
+		trans: trans,
+		queue: makeSendQueue(),
+
+		blockedChats: make(chan int64, blockedChatsBufferSize),
 	}
 
+	go bot.processSendQueue()
+
 	return
 }
 
@@ -30,6 +63,12 @@ func (telegramChat *TelegramChat) GetBot() *tgbotapi.BotAPI {
 	return telegramChat.bot
 }
 
+// GetBlockedChats returns the channel with the ids of the chats where the messages can't be delivered
+// because the users blocked the bot
+func (telegramChat *TelegramChat) GetBlockedChats() <-chan int64 {
+	return telegramChat.blockedChats
+}
+
 func (telegramChat *TelegramChat) SetDebugModeEnabled(isEnabled bool) {
 	telegramChat.bot.Debug = isEnabled
 }
@@ -38,16 +77,220 @@ func (telegramChat *TelegramChat) GetBotUsername() string {
 	return telegramChat.bot.Self.UserName
 }
 
+// processSendQueue delivers the messages from the outbox, it works in its own goroutine
+// and continues sending the messages that were left undelivered after a restart
+func (telegramChat *TelegramChat) processSendQueue() {
+	// the database can't recover from its errors, they are logged before exiting
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger := logrus.WithField("component", "outbox")
+			if entry, ok := recovered.(*logrus.Entry); ok {
+				logger.Fatal(entry.Message)
+			}
+			logger.Fatal(recovered)
+		}
+	}()
+
+	var lastLoadTime, lastCleanupTime time.Time
+	var lastLoadedId int64
+	for {
+		if time.Since(lastLoadTime) >= outboxPollInterval {
+			if loadedId := telegramChat.loadOutboxMessages(0); loadedId > lastLoadedId {
+				lastLoadedId = loadedId
+			}
+			lastLoadTime = time.Now()
+		}
+
+		if time.Since(lastCleanupTime) >= outboxCleanupInterval {
+			telegramChat.db.RemoveDeliveredOutboxMessages(time.Now().Add(-deliveredMessagesLifetime).Unix())
+			lastCleanupTime = time.Now()
+		}
+
+		message, waitTime, isFound := telegramChat.queue.pop(time.Now())
+		if isFound {
+			telegramChat.deliverMessage(message)
+			time.Sleep(globalSendInterval)
+			continue
+		}
+
+		if waitTime > outboxPollInterval {
+			waitTime = outboxPollInterval
+		}
+
+		select {
+		case <-telegramChat.queue.wakeUp:
+			// the messages that were added before are loaded by the regular poll
+			if loadedId := telegramChat.loadOutboxMessages(lastLoadedId); loadedId > lastLoadedId {
+				lastLoadedId = loadedId
+			}
+		case <-time.After(waitTime):
+		}
+	}
+}
+
+// loadOutboxMessages queues the due messages with ids greater than afterId and returns the greatest loaded id
+func (telegramChat *TelegramChat) loadOutboxMessages(afterId int64) (lastLoadedId int64) {
+	messages := telegramChat.db.GetNewDueOutboxMessages(afterId, time.Now().Unix(), outboxLoadLimit)
+	for _, message := range messages {
+		if message.Id > lastLoadedId {
+			lastLoadedId = message.Id
+		}
+		telegramChat.queue.push(outgoingMessage{
+			id:            message.Id,
+			chatId:        message.ChatId,
+			text:          message.Text,
+			priority:      messagePriority(message.Priority),
+			attempts:      message.Attempts,
+			editMessageId: message.EditMessageId,
+			documentName:  message.DocumentName,
+		})
+	}
+	return
+}
+
+// isUserBlockedError checks whether the message can't be delivered because
+// the user blocked the bot or deleted their account
+func isUserBlockedError(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	return ok && strings.HasPrefix(apiErr.Message, "Forbidden")
+}
+
+// isNotEditableError checks whether the edited message is gone or already has this text,
+// retrying such edits makes no sense
+func isNotEditableError(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	return ok && strings.HasPrefix(apiErr.Message, "Bad Request")
+}
+
+func getRetryDelay(attempts int) time.Duration {
+	return firstRetryDelay * time.Duration(1<<uint(attempts))
+}
+
+func (telegramChat *TelegramChat) deliverMessage(message outgoingMessage) {
+	var msg tgbotapi.Chattable
+	if message.documentName != "" {
+		msg = tgbotapi.NewDocumentUpload(message.chatId, tgbotapi.FileBytes{
+			Name:  message.documentName,
+			Bytes: []byte(message.text),
+		})
+	} else if message.editMessageId != 0 {
+		edit := tgbotapi.NewEditMessageText(message.chatId, int(message.editMessageId), message.text)
+		edit.ParseMode = "HTML"
+		msg = edit
+	} else {
+		newMessage := tgbotapi.NewMessage(message.chatId, message.text)
+		newMessage.ParseMode = "HTML"
+		msg = newMessage
+	}
+
+	sentMessage, err := telegramChat.bot.Send(msg)
+	if err == nil {
+		telegramChat.db.MarkOutboxMessageDelivered(message.id, time.Now().Unix(), int64(sentMessage.MessageID))
+		return
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"chat_id":    message.chatId,
+		"message_id": message.id,
+	})
+
+	if apiErr, ok := err.(tgbotapi.Error); ok && apiErr.RetryAfter > 0 {
+		logger.Warningf("Too many requests, retrying after %d seconds", apiErr.RetryAfter)
+		telegramChat.queue.pause(time.Now().Add(time.Duration(apiErr.RetryAfter) * time.Second))
+		telegramChat.queue.pushFront(message)
+		return
+	}
+
+	metrics.CountSendFailure()
+
+	if message.editMessageId != 0 && isNotEditableError(err) {
+		logger.Infof("Can't edit message: %s", err.Error())
+		telegramChat.db.MarkOutboxMessageFailed(message.id, err.Error())
+		return
+	}
+
+	if isUserBlockedError(err) {
+		logger.Infof("User blocked the bot: %s", err.Error())
+		telegramChat.db.MarkOutboxMessageFailed(message.id, err.Error())
+		telegramChat.blockedChats <- message.chatId
+		return
+	}
+
+	if message.attempts+1 >= maxDeliveryAttempts {
+		logger.Errorf("Can't send message, giving up: %s", err.Error())
+		telegramChat.db.MarkOutboxMessageFailed(message.id, err.Error())
+		if message.documentName != "" {
+			telegramChat.SendMessage(message.chatId, telegramChat.trans("warn_document_not_sent", map[string]interface{}{
+				"Name": message.documentName,
+			}))
+		}
+	} else {
+		logger.Warningf("Can't send message, will retry: %s", err.Error())
+		nextAttemptTime := time.Now().Add(getRetryDelay(message.attempts))
+		telegramChat.db.RescheduleOutboxMessage(message.id, nextAttemptTime.Unix(), err.Error())
+	}
+}
+
+// addToOutbox queues the messages, questionId is zero for the messages that are not questions
+func (telegramChat *TelegramChat) addToOutbox(chatIds []int64, questionId int64, message string, priority messagePriority) {
+	telegramChat.db.AddOutboxQuestionMessages(chatIds, questionId, message, int(priority), time.Now().Unix())
+	telegramChat.queue.notify()
+}
+
+// SendMessage queues a reply to a user that is interacting with the bot
 func (telegramChat *TelegramChat) SendMessage(chatId int64, message string) {
-	msg := tgbotapi.NewMessage(chatId, message)
-	msg.ParseMode = "HTML"
-	telegramChat.bot.Send(msg)
+	telegramChat.addToOutbox([]int64{chatId}, 0, message, interactivePriority)
 }
 
-func (telegramChat *TelegramChat) SendQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+// BroadcastMessage queues a message to many users with lower priority than replies,
+// users that have quiet hours now will receive it after the quiet hours end
+func (telegramChat *TelegramChat) BroadcastMessage(chatIds []int64, message string) {
+	telegramChat.broadcast(chatIds, 0, message)
+}
+
+func (telegramChat *TelegramChat) broadcast(chatIds []int64, questionId int64, message string) {
+	now := time.Now()
+	quietHours := telegramChat.db.GetUsersQuietHours()
+
+	deferredChatIds := make(map[int64][]int64)
+	var immediateChatIds []int64
+	for _, chatId := range chatIds {
+		if userQuietHours, ok := quietHours[chatId]; ok {
+			deliveryTime := getDeliveryTime(userQuietHours, now)
+			if deliveryTime.After(now) {
+				deferredChatIds[deliveryTime.Unix()] = append(deferredChatIds[deliveryTime.Unix()], chatId)
+				continue
+			}
+		}
+		immediateChatIds = append(immediateChatIds, chatId)
+	}
+
+	telegramChat.addToOutbox(immediateChatIds, questionId, message, broadcastPriority)
+
+	for deliveryTime, chatIds := range deferredChatIds {
+		telegramChat.db.AddOutboxQuestionMessages(chatIds, questionId, message, int(broadcastPriority), deliveryTime)
+	}
+}
+
+func (telegramChat *TelegramChat) getQuestionMessage(db *database.Database, questionId int64) string {
 	var buffer bytes.Buffer
 
+	if position, count, ok := db.GetQuestionSurveyProgress(questionId); ok {
+		buffer.WriteString(telegramChat.trans("survey_progress", map[string]interface{}{
+			"Position": position,
+			"Count":    count,
+		}) + "\n")
+	}
 	buffer.WriteString(db.GetQuestionText(questionId) + "\n")
+	if db.IsQuestionEdited(questionId) {
+		buffer.WriteString(telegramChat.trans("question_edited_mark") + "\n")
+	}
+	if db.IsQuestionPublic(questionId) {
+		buffer.WriteString(telegramChat.trans("warn_public_question") + "\n")
+	}
+	if db.GetQuestionType(questionId) == database.QuestionTypeRanked {
+		buffer.WriteString(telegramChat.trans("ranked_question_hint") + "\n")
+	}
 
 	variants := db.GetQuestionVariants(questionId)
 	for i, variant := range variants {
@@ -55,17 +298,38 @@ func (telegramChat *TelegramChat) SendQuestion(db *database.Database, questionId
 	}
 
 	buffer.WriteString("/skip")
-	message := buffer.String()
+	buffer.WriteString(fmt.Sprintf("\n/report%d", questionId))
+	return buffer.String()
+}
 
-	for _, chatId := range usersChatIds {
-		telegramChat.SendMessage(chatId, message)
-	}
+func (telegramChat *TelegramChat) SendQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+	telegramChat.addToOutbox(usersChatIds, questionId, telegramChat.getQuestionMessage(db, questionId), interactivePriority)
 
 	db.UnmarkUsersReady(usersChatIds)
+	db.SetUsersCurrentQuestion(usersChatIds, questionId)
 }
 
-func appendCommand(buffer *bytes.Buffer, dialogId string, variantId string, variantText string) {
-	buffer.WriteString(fmt.Sprintf("\n/%s_%s - %s", dialogId, variantId, variantText))
+func (telegramChat *TelegramChat) BroadcastQuestion(db *database.Database, questionId int64, usersChatIds []int64) {
+	telegramChat.broadcast(usersChatIds, questionId, telegramChat.getQuestionMessage(db, questionId))
+	db.UnmarkUsersReady(usersChatIds)
+	db.SetUsersCurrentQuestion(usersChatIds, questionId)
+}
+
+// UpdateQuestion replaces the question in the messages that are waiting in the outbox
+// and edits the messages that users already received
+func (telegramChat *TelegramChat) UpdateQuestion(db *database.Database, questionId int64) {
+	message := telegramChat.getQuestionMessage(db, questionId)
+	db.UpdatePendingQuestionMessages(questionId, message)
+	db.AddOutboxEdits(db.GetDeliveredQuestionMessages(questionId), message, int(broadcastPriority), time.Now().Unix())
+	telegramChat.queue.notify()
+}
+
+func appendCommand(buffer *bytes.Buffer, dialogId string, variantId string, additionalId int64, variantText string) {
+	if additionalId != 0 {
+		buffer.WriteString(fmt.Sprintf("\n/%s_%s_%d - %s", dialogId, variantId, additionalId, variantText))
+	} else {
+		buffer.WriteString(fmt.Sprintf("\n/%s_%s - %s", dialogId, variantId, variantText))
+	}
 }
 
 func (telegramChat *TelegramChat) SendDialog(dialog *dialog.Dialog, chatId int64) {
@@ -74,8 +338,14 @@ func (telegramChat *TelegramChat) SendDialog(dialog *dialog.Dialog, chatId int64
 	buffer.WriteString(dialog.Text)
 
 	for _, variant := range dialog.Variants {
-		appendCommand(&buffer, dialog.Id, variant.Id, variant.Text)
+		appendCommand(&buffer, dialog.Id, variant.Id, dialog.AdditionalId, variant.Text)
 	}
 
 	telegramChat.SendMessage(chatId, buffer.String())
 }
+
+// SendDocument queues a text file as a reply to a user that is interacting with the bot
+func (telegramChat *TelegramChat) SendDocument(chatId int64, fileName string, content []byte) {
+	telegramChat.db.AddOutboxDocument(chatId, fileName, string(content), int(interactivePriority), time.Now().Unix())
+	telegramChat.queue.notify()
+}